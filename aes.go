@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -14,7 +15,7 @@ import (
 )
 
 const (
-	aesCurrentVaultVersion = 1
+	aesCurrentVaultVersion = 2
 	aesVaultFileExt        = "enc"
 )
 
@@ -22,7 +23,16 @@ const (
 type AESState struct {
 	Metadata `yaml:"metadata"`
 
-	Version int               `json:"version"`
+	Version int                     `yaml:"version"`
+	ID      string                  `yaml:"id"`
+	Secrets map[string]*SecretEntry `yaml:"secrets"`
+}
+
+// aesStateV1 is the version 1 layout of AESState, in which secrets were stored as bare values.
+type aesStateV1 struct {
+	Metadata `yaml:"metadata"`
+
+	Version int               `yaml:"version"`
 	ID      string            `yaml:"id"`
 	Secrets map[string]string `yaml:"secrets"`
 }
@@ -35,7 +45,15 @@ type AES256Vault struct {
 
 	state    *AESState
 	resolver *KeyResolver
-	dek      string
+	// dek is the random data key that encrypts the vault state
+	dek string
+	// kek is the key-encryption key that unlocked the data key
+	kek    string
+	slots  []aesKeySlot
+	source *KeySource
+	// kdf derives key-encryption keys from passphrases
+	kdf         crypto.KDF
+	historySize int
 }
 
 // GenerateEncryptionKey generates a new AES encryption key
@@ -76,16 +94,29 @@ func NewAES256Vault(cfg *Config) (*AES256Vault, error) {
 	if cfg.Aes == nil {
 		return nil, fmt.Errorf("AES configuration is required")
 	}
+	if err := validateHistorySize(cfg.Aes.HistorySize); err != nil {
+		return nil, err
+	}
 
 	path := filepath.Join(
 		filepath.Clean(cfg.Aes.StoragePath),
 		filepath.Clean(fmt.Sprintf("%s-%s.%s", vaultFileBase, cfg.ID, aesVaultFileExt)),
 	)
 
+	kdf := crypto.Scrypt(crypto.DefaultScryptParams)
+	if cfg.Aes.KDF != nil {
+		var err error
+		if kdf, err = crypto.NewKDF(*cfg.Aes.KDF); err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
+	}
+
 	vault := &AES256Vault{
-		id:       cfg.ID,
-		fullPath: path,
-		resolver: NewKeyResolver(cfg.Aes.KeySource),
+		id:          cfg.ID,
+		fullPath:    path,
+		resolver:    NewKeyResolver(cfg.Aes.KeySource),
+		kdf:         kdf,
+		historySize: cfg.Aes.HistorySize,
 	}
 
 	if err := vault.load(); err != nil {
@@ -102,11 +133,33 @@ func NewAES256Vault(cfg *Config) (*AES256Vault, error) {
 }
 
 func (v *AES256Vault) init() error {
-	keys, err := v.resolver.ResolveKeys()
+	candidates, err := v.resolver.candidates()
 	if err != nil {
 		return fmt.Errorf("no encryption key available for new vault: %w", err)
 	}
-	v.dek = keys[0]
+
+	// the data key is wrapped under every key and passphrase currently available from the configured sources
+	v.dek, err = crypto.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	v.slots = nil
+	seen := make(map[string]bool)
+	for i, c := range candidates {
+		slot, kek, err := c.wrap(v.dek, v.kdf)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			source := c.source
+			v.kek, v.source = kek, &source
+		}
+		if seen[slot.ID] {
+			continue
+		}
+		seen[slot.ID] = true
+		v.slots = append(v.slots, slot)
+	}
 
 	now := time.Now()
 	v.state = &AESState{
@@ -116,7 +169,7 @@ func (v *AES256Vault) init() error {
 			Created:      now,
 			LastModified: now,
 		},
-		Secrets: make(map[string]string),
+		Secrets: make(map[string]*SecretEntry),
 	}
 
 	return v.save()
@@ -136,47 +189,83 @@ func (v *AES256Vault) load() error {
 		return nil
 	}
 
-	// try to decrypt the vault file using available keys
-	dataStr, key, err := v.resolver.TryDecrypt(string(data))
+	// try to decrypt the vault file using available keys, migrating files written with older layouts
+	var dataStr string
+	switch aesFileFormat(data) {
+	case aesFormatBinary:
+		dataStr, err = v.openFile(data)
+	case aesFormatEnvelope:
+		dataStr, err = v.openEnvelope(data)
+	default:
+		dataStr, err = v.openLegacy(data)
+	}
 	if err != nil {
 		return err
 	}
-	v.dek = key
 
-	var state AESState
-	if err := yaml.Unmarshal([]byte(dataStr), &state); err != nil {
-		return fmt.Errorf("failed to unmarshal vault state: %w", err)
+	state, err := decodeAESState([]byte(dataStr))
+	if err != nil {
+		return err
 	}
-	v.state = &state
+	// files written before the vault ID was bound into the ciphertext are only accepted for their own vault
+	if state.ID != v.id {
+		return fmt.Errorf("%w: vault file belongs to vault %q, not %q", ErrDecryptionFailed, state.ID, v.id)
+	}
+	v.state = state
 	return nil
 }
 
+// decodeAESState unmarshals the decrypted vault state, migrating it from older versions when needed.
+func decodeAESState(data []byte) (*AESState, error) {
+	var probe struct {
+		Version int `yaml:"version"`
+	}
+	if err := yaml.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault state: %w", err)
+	}
+
+	switch {
+	case probe.Version > aesCurrentVaultVersion:
+		return nil, fmt.Errorf("unsupported vault version %d", probe.Version)
+	case probe.Version <= 1:
+		var legacy aesStateV1
+		if err := yaml.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal vault state: %w", err)
+		}
+		return &AESState{
+			Metadata: legacy.Metadata,
+			Version:  aesCurrentVaultVersion,
+			ID:       legacy.ID,
+			Secrets:  migrateSecretsV1(legacy.Secrets, legacy.LastModified),
+		}, nil
+	}
+
+	var state AESState
+	if err := yaml.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault state: %w", err)
+	}
+	return &state, nil
+}
+
 // save encrypts and writes the vault contents to disk
 func (v *AES256Vault) save() error {
 	if v.state == nil {
 		return nil
 	}
 
-	if v.dek == "" {
+	if v.dek == "" || len(v.slots) == 0 {
 		return fmt.Errorf("no encryption key available for saving")
 	}
 
 	v.state.LastModified = time.Now()
-	data, err := yaml.Marshal(v.state)
-	if err != nil {
-		return fmt.Errorf("failed to marshal vault state: %w", err)
-	}
-	encryptedDataStr, err := crypto.EncryptValue(v.dek, string(data))
-	if err != nil {
-		return fmt.Errorf("failed to encrypt vault state: %w", err)
-	}
 
 	// write to the file atomically
 	if err := os.MkdirAll(filepath.Dir(v.fullPath), 0750); err != nil {
 		return fmt.Errorf("failed to create vault directory: %w", err)
 	}
 	tempFile := v.fullPath + ".tmp"
-	if err := os.WriteFile(tempFile, []byte(encryptedDataStr), 0600); err != nil {
+	if err := v.writeFile(tempFile); err != nil {
+		_ = os.Remove(tempFile)
 		return fmt.Errorf("failed to write temp vault file: %w", err)
 	}
 
@@ -188,6 +277,48 @@ func (v *AES256Vault) save() error {
 	return nil
 }
 
+// RotateKey re-encrypts the vault with a new data key wrapped only under newKey. Every other key is removed from
+// the vault and must be added again with AddKey. The vault file is replaced atomically, so it can always be
+// decrypted with either the previous or the new key. Key sources must be updated to provide the new key before
+// the vault is opened again.
+func (v *AES256Vault) RotateKey(newKey string) error {
+	if err := ValidateEncryptionKey(newKey); err != nil {
+		return fmt.Errorf("%w: invalid encryption key: %w", ErrInvalidConfig, err)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	dek, err := crypto.GenerateKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate data key: %w", err)
+	}
+	slot, err := wrapDataKey(dek, newKey)
+	if err != nil {
+		return err
+	}
+
+	prevDEK, prevKEK, prevSlots, prevSource := v.dek, v.kek, v.slots, v.source
+	v.dek, v.kek, v.slots, v.source = dek, newKey, []aesKeySlot{slot}, nil
+	if err := v.save(); err != nil {
+		v.dek, v.kek, v.slots, v.source = prevDEK, prevKEK, prevSlots, prevSource
+		return fmt.Errorf("failed to re-encrypt vault: %w", err)
+	}
+
+	return nil
+}
+
+// ActiveKeySource returns the configured key source whose key currently unlocks the vault
+func (v *AES256Vault) ActiveKeySource() (KeySource, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if v.source == nil {
+		return KeySource{}, fmt.Errorf("%w: vault key is not provided by any configured key source", ErrNoAccess)
+	}
+	return *v.source, nil
+}
+
 func (v *AES256Vault) ID() string {
 	return v.id
 }
@@ -203,37 +334,82 @@ func (v *AES256Vault) Metadata() Metadata {
 }
 
 func (v *AES256Vault) GetSecret(key string) (Secret, error) {
+	return v.GetSecretContext(context.Background(), key)
+}
+
+func (v *AES256Vault) GetSecretContext(ctx context.Context, key string) (Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
-	value, exists := v.state.Secrets[key]
+	entry, exists := v.state.Secrets[key]
 	if !exists {
 		return nil, ErrSecretNotFound
 	}
+	if entry.expired() {
+		return nil, ErrSecretExpired
+	}
 
-	return NewSecretValue([]byte(value)), nil
+	return NewSecretValue([]byte(entry.Value)), nil
 }
 
 func (v *AES256Vault) SetSecret(key string, secret Secret) error {
+	return v.SetSecretContext(context.Background(), key, secret)
+}
+
+func (v *AES256Vault) SetSecretContext(ctx context.Context, key string, secret Secret) error {
+	return v.setSecret(ctx, key, secret, SetOptions{})
+}
+
+func (v *AES256Vault) SetSecretWithOptions(key string, secret Secret, opts SetOptions) error {
+	return v.setSecret(context.Background(), key, secret, opts)
+}
+
+func (v *AES256Vault) setSecret(ctx context.Context, key string, secret Secret, opts SetOptions) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if err := ValidateSecretKey(key); err != nil {
 		return err
 	}
+	if len(opts.Recipients) > 0 {
+		return fmt.Errorf("%w: secret recipients are only supported by age vaults", ErrNotSupported)
+	}
 
 	if v.state.Secrets == nil {
-		v.state.Secrets = make(map[string]string)
+		v.state.Secrets = make(map[string]*SecretEntry)
 	}
 
-	v.state.Secrets[key] = secret.PlainTextString()
+	entry, exists := v.state.Secrets[key]
+	if exists {
+		entry.setValue(secret.PlainTextString(), v.historySize)
+	} else {
+		entry = newSecretEntry(secret.PlainTextString())
+		v.state.Secrets[key] = entry
+	}
+	entry.Metadata.ExpiresAt = opts.expiresAt()
 	return v.save()
 }
 
 func (v *AES256Vault) DeleteSecret(key string) error {
+	return v.DeleteSecretContext(context.Background(), key)
+}
+
+func (v *AES256Vault) DeleteSecretContext(ctx context.Context, key string) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	_, exists := v.state.Secrets[key]
 	if !exists {
 		return ErrSecretNotFound
@@ -244,6 +420,14 @@ func (v *AES256Vault) DeleteSecret(key string) error {
 }
 
 func (v *AES256Vault) ListSecrets() ([]string, error) {
+	return v.ListSecretsContext(context.Background())
+}
+
+func (v *AES256Vault) ListSecretsContext(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -255,6 +439,14 @@ func (v *AES256Vault) ListSecrets() ([]string, error) {
 }
 
 func (v *AES256Vault) HasSecret(key string) (bool, error) {
+	return v.HasSecretContext(context.Background(), key)
+}
+
+func (v *AES256Vault) HasSecretContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -262,12 +454,102 @@ func (v *AES256Vault) HasSecret(key string) (bool, error) {
 	return exists, nil
 }
 
+func (v *AES256Vault) GetSecretMetadata(key string) (SecretMetadata, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return SecretMetadata{}, ErrSecretNotFound
+	}
+
+	return entry.Metadata.copy(), nil
+}
+
+func (v *AES256Vault) ListSecretsWithMetadata() (map[string]SecretMetadata, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return secretsMetadata(v.state.Secrets), nil
+}
+
+func (v *AES256Vault) SetSecretMetadata(key, description string, tags []string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	entry.Metadata.Description = description
+	entry.Metadata.Tags = append([]string(nil), tags...)
+	return v.save()
+}
+
+func (v *AES256Vault) GetSecretVersion(key string, version int) (Secret, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+
+	value, err := entry.readVersion(key, version)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretValue([]byte(value)), nil
+}
+
+func (v *AES256Vault) ListSecretVersions(key string) ([]SecretVersionInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+
+	return entry.versions(), nil
+}
+
+func (v *AES256Vault) RollbackSecret(key string, version int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	if err := entry.rollback(key, version, v.historySize); err != nil {
+		return err
+	}
+	return v.save()
+}
+
+func (v *AES256Vault) PurgeExpired() ([]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	purged := purgeExpired(v.state.Secrets)
+	if len(purged) == 0 {
+		return purged, nil
+	}
+	return purged, v.save()
+}
+
 func (v *AES256Vault) Close() error {
 	// clear the secret state from memory
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
 	v.dek = ""
+	v.kek = ""
+	v.slots = nil
+	v.source = nil
 	v.state = nil
 
 	return nil
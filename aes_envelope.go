@@ -0,0 +1,312 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/flowexec/vault/crypto"
+)
+
+// aesEnvelope is the JSON layout of AES vault files written before the binary header was introduced. The vault
+// state is encrypted with a random data key, and the data key is wrapped separately under each key-encryption key
+// that is allowed to unlock the vault.
+type aesEnvelope struct {
+	Version int          `json:"version"`
+	Keys    []aesKeySlot `json:"keys"`
+	Data    string       `json:"data"`
+}
+
+// aesKeySlot holds the data key wrapped under a single key-encryption key
+type aesKeySlot struct {
+	// ID identifies the key-encryption key without revealing it
+	ID      string `json:"id"`
+	Wrapped string `json:"wrapped"`
+	// KDF is set when the key-encryption key is derived from a passphrase
+	KDF *crypto.KDFParams `json:"kdf,omitempty"`
+}
+
+func wrapDataKey(dek, kek string) (aesKeySlot, error) {
+	wrapped, err := crypto.EncryptValue(kek, dek)
+	if err != nil {
+		return aesKeySlot{}, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	return aesKeySlot{ID: KeyID(kek), Wrapped: wrapped}, nil
+}
+
+// wrapDataKeyWithPassphrase derives a key-encryption key from the passphrase with a new random salt and wraps
+// the data key under it. The derived key is returned along with the slot.
+func wrapDataKeyWithPassphrase(dek, passphrase string, kdf crypto.KDF) (aesKeySlot, string, error) {
+	kek, params, err := crypto.DeriveKeyWithKDF(kdf, []byte(passphrase), nil)
+	if err != nil {
+		return aesKeySlot{}, "", fmt.Errorf("failed to derive key from passphrase: %w", err)
+	}
+
+	slot, err := wrapDataKey(dek, kek)
+	if err != nil {
+		return aesKeySlot{}, "", err
+	}
+	slot.KDF = &params
+	return slot, kek, nil
+}
+
+// wrap creates the key slot for the candidate, returning the slot and the key-encryption key. Passphrases are
+// derived with the given KDF.
+func (c keyCandidate) wrap(dek string, kdf crypto.KDF) (aesKeySlot, string, error) {
+	if c.passphrase != "" {
+		return wrapDataKeyWithPassphrase(dek, c.passphrase, kdf)
+	}
+	slot, err := wrapDataKey(dek, c.key)
+	return slot, c.key, err
+}
+
+// unwrap returns the data key and key-encryption key from the first slot the candidate can unlock
+func (c keyCandidate) unwrap(slots []aesKeySlot) (string, string, error) {
+	if c.passphrase == "" {
+		dek, err := unwrapDataKey(slots, c.key)
+		return dek, c.key, err
+	}
+
+	for _, slot := range slots {
+		if slot.KDF == nil {
+			continue
+		}
+		kek, err := crypto.RederiveKey([]byte(c.passphrase), *slot.KDF)
+		if err != nil || KeyID(kek) != slot.ID {
+			continue // the slot belongs to another passphrase
+		}
+		dek, err := crypto.DecryptValue(kek, slot.Wrapped)
+		if err != nil {
+			return "", "", fmt.Errorf("%w: failed to unwrap data key: %w", ErrDecryptionFailed, err)
+		}
+		return dek, kek, nil
+	}
+	return "", "", fmt.Errorf("%w: no data key wrapped for passphrase", ErrDecryptionFailed)
+}
+
+// unwrapDataKey returns the data key from the slot belonging to the key-encryption key
+func unwrapDataKey(slots []aesKeySlot, kek string) (string, error) {
+	id := KeyID(kek)
+	for _, slot := range slots {
+		if slot.ID != id {
+			continue
+		}
+		dek, err := crypto.DecryptValue(kek, slot.Wrapped)
+		if err != nil {
+			return "", fmt.Errorf("%w: failed to unwrap data key: %w", ErrDecryptionFailed, err)
+		}
+		return dek, nil
+	}
+	return "", fmt.Errorf("%w: no data key wrapped for key %s", ErrDecryptionFailed, id)
+}
+
+// openFile decrypts a vault file written with the binary header layout
+func (v *AES256Vault) openFile(data []byte) (string, error) {
+	f, err := decodeAESFile(data)
+	if err != nil {
+		return "", err
+	}
+
+	aad := aesFileAAD(v.id, f.Version)
+	if f.Cipher == aesCipherGCM {
+		return v.unlock(f.Header.Keys, func(dek string) (string, error) {
+			return crypto.DecryptValueWithAAD(dek, string(f.Ciphertext), aad)
+		})
+	}
+	return v.unlock(f.Header.Keys, func(dek string) (string, error) {
+		r, err := crypto.DecryptStream(bytes.NewReader(f.Ciphertext), dek, aad)
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := io.ReadAll(r)
+		return string(plaintext), err
+	})
+}
+
+// openEnvelope decrypts a vault file written with the JSON envelope layout. The key slots are kept, so the vault is
+// converted to the binary header layout on the next save.
+func (v *AES256Vault) openEnvelope(data []byte) (string, error) {
+	var env aesEnvelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return "", fmt.Errorf("failed to parse vault file: %w", err)
+	}
+	if env.Version > aesFormatEnvelope {
+		return "", fmt.Errorf("unsupported vault file version %d", env.Version)
+	}
+	return v.unlock(env.Keys, func(dek string) (string, error) {
+		return crypto.DecryptValue(dek, env.Data)
+	})
+}
+
+// unlock unwraps the data key with the first available key or passphrase and decrypts the vault state with it
+func (v *AES256Vault) unlock(slots []aesKeySlot, decrypt func(dek string) (string, error)) (string, error) {
+	// the KDF parameters come from the vault file and are checked before any key is derived with them
+	for _, slot := range slots {
+		if slot.KDF == nil {
+			continue
+		}
+		if _, err := crypto.NewKDF(*slot.KDF); err != nil {
+			return "", fmt.Errorf("%w: invalid key slot %s: %w", ErrDecryptionFailed, slot.ID, err)
+		}
+	}
+
+	var dek, kek string
+	c, err := v.resolver.firstCandidate(func(c keyCandidate) error {
+		var err error
+		if dek, kek, err = c.unwrap(slots); err != nil {
+			return fmt.Errorf("%w: failed to unwrap data key with any available key", ErrDecryptionFailed)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := decrypt(dek)
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to decrypt vault state: %w", ErrDecryptionFailed, err)
+	}
+	v.dek, v.kek, v.slots, v.source = dek, kek, slots, &c.source
+	return plaintext, nil
+}
+
+// openLegacy decrypts a headerless vault file that predates envelope encryption. A new data key is generated and
+// wrapped under the key that decrypted the file, so the vault is converted to the current layout on the next save.
+func (v *AES256Vault) openLegacy(data []byte) (string, error) {
+	var plaintext string
+	failed := fmt.Errorf("%w: failed to decrypt data with any available key", ErrDecryptionFailed)
+	c, err := v.resolver.firstCandidate(func(c keyCandidate) error {
+		if c.key == "" {
+			return failed // legacy files predate passphrase sources
+		}
+		var err error
+		if plaintext, err = crypto.DecryptValue(c.key, string(data)); err != nil {
+			return failed
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	dek, err := crypto.GenerateKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+	slot, err := wrapDataKey(dek, c.key)
+	if err != nil {
+		return "", err
+	}
+
+	v.dek, v.kek, v.slots, v.source = dek, c.key, []aesKeySlot{slot}, &c.source
+	return plaintext, nil
+}
+
+// writeFile streams the vault state, encrypted with the data key, to a new vault file at path
+func (v *AES256Vault) writeFile(path string) error {
+	f, err := os.OpenFile(filepath.Clean(path), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create vault file: %w", err)
+	}
+	defer f.Close()
+
+	if err := writeAESFileHeader(f, aesCipherStream, aesFileHeader{Keys: v.slots}); err != nil {
+		return err
+	}
+	w, err := crypto.EncryptStream(f, v.dek, aesFileAAD(v.id, aesCurrentFormatVersion))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt vault state: %w", err)
+	}
+
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(v.state); err != nil {
+		return fmt.Errorf("failed to marshal vault state: %w", err)
+	}
+	if err := enc.Close(); err != nil {
+		return fmt.Errorf("failed to marshal vault state: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encrypt vault state: %w", err)
+	}
+	return f.Close()
+}
+
+// AddKey wraps the vault's data key under an additional key-encryption key, allowing the holder of that key to
+// unlock the vault without sharing any other key.
+func (v *AES256Vault) AddKey(key string) error {
+	if err := ValidateEncryptionKey(key); err != nil {
+		return fmt.Errorf("%w: invalid encryption key: %w", ErrInvalidConfig, err)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	id := KeyID(key)
+	for _, slot := range v.slots {
+		if slot.ID == id {
+			return nil
+		}
+	}
+
+	slot, err := wrapDataKey(v.dek, key)
+	if err != nil {
+		return err
+	}
+	v.slots = append(v.slots, slot)
+	return v.save()
+}
+
+// AddPassphrase wraps the vault's data key under a key derived from an additional passphrase
+func (v *AES256Vault) AddPassphrase(passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("%w: passphrase cannot be empty", ErrInvalidConfig)
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	slot, _, err := wrapDataKeyWithPassphrase(v.dek, passphrase, v.kdf)
+	if err != nil {
+		return err
+	}
+	v.slots = append(v.slots, slot)
+	return v.save()
+}
+
+// RemoveKey removes the wrapped data key for the key-encryption key with the given ID. The data key itself is
+// unchanged, so copies of the vault file taken before the removal can still be unlocked with the removed key;
+// use RotateKey to generate a new data key when a key is compromised.
+func (v *AES256Vault) RemoveKey(keyID string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if len(v.slots) <= 1 {
+		return fmt.Errorf("cannot remove the last key - at least one key is required to unlock the vault")
+	}
+
+	for i, slot := range v.slots {
+		if slot.ID == keyID {
+			v.slots = append(v.slots[:i:i], v.slots[i+1:]...)
+			return v.save()
+		}
+	}
+
+	return fmt.Errorf("key %s not found", keyID)
+}
+
+// ListKeys returns the IDs of the key-encryption keys that can unlock the vault
+func (v *AES256Vault) ListKeys() ([]string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	ids := make([]string, 0, len(v.slots))
+	for _, slot := range v.slots {
+		ids = append(ids, slot.ID)
+	}
+	return ids, nil
+}
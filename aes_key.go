@@ -1,6 +1,8 @@
 package vault
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +11,21 @@ import (
 	"github.com/flowexec/vault/crypto"
 )
 
+// KeyID returns a short, non-reversible identifier for an encryption key
+func KeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// keyCandidate is a key-encryption key, or a passphrase to derive one from, provided by a configured key source
+type keyCandidate struct {
+	source     KeySource
+	key        string
+	passphrase string
+}
+
+var errNoCandidates = fmt.Errorf("%w: no encryption keys or passphrases found", ErrNoAccess)
+
 type KeyResolver struct {
 	sources []KeySource
 }
@@ -28,15 +45,8 @@ func (r *KeyResolver) ResolveKeys() ([]string, error) {
 	var keys []string
 
 	for _, source := range r.sources {
-		switch source.Type {
-		case envSource:
-			if key := r.fromEnvironment(source.Name); key != "" {
-				keys = append(keys, key)
-			}
-		case fileSource:
-			if key, err := r.fromFile(source.Path); err == nil && key != "" {
-				keys = append(keys, key)
-			}
+		if key := r.resolveSource(source); key != "" {
+			keys = append(keys, key)
 		}
 	}
 
@@ -47,6 +57,58 @@ func (r *KeyResolver) ResolveKeys() ([]string, error) {
 	return keys, nil
 }
 
+// candidates returns every key and passphrase available from the configured sources, in order of preference
+func (r *KeyResolver) candidates() ([]keyCandidate, error) {
+	var candidates []keyCandidate
+	for _, source := range r.sources {
+		if c, ok := r.candidate(source); ok {
+			candidates = append(candidates, c)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil, errNoCandidates
+	}
+	return candidates, nil
+}
+
+// firstCandidate resolves the sources in order of preference and returns the first candidate that try accepts.
+// Sources after it are not resolved, so their commands are not run. It returns errNoCandidates if no source
+// provided a key or passphrase, or the last error returned by try.
+func (r *KeyResolver) firstCandidate(try func(keyCandidate) error) (keyCandidate, error) {
+	err := errNoCandidates
+	for _, source := range r.sources {
+		c, ok := r.candidate(source)
+		if !ok {
+			continue
+		}
+		if err = try(c); err == nil {
+			return c, nil
+		}
+	}
+	return keyCandidate{}, err
+}
+
+// candidate returns the key or passphrase provided by the source, if it is available
+func (r *KeyResolver) candidate(source KeySource) (keyCandidate, bool) {
+	if source.Type == passphraseSource {
+		passphrase, err := readPassphrase(source.Name, source.Path)
+		return keyCandidate{source: source, passphrase: passphrase}, err == nil && passphrase != ""
+	}
+	key := r.resolveSource(source)
+	return keyCandidate{source: source, key: key}, key != ""
+}
+
+// SourceForKey returns the configured key source that currently provides the given key
+func (r *KeyResolver) SourceForKey(key string) (KeySource, error) {
+	for _, source := range r.sources {
+		if k := r.resolveSource(source); k != "" && k == key {
+			return source, nil
+		}
+	}
+	return KeySource{}, fmt.Errorf("%w: key is not provided by any configured key source", ErrNoAccess)
+}
+
 func (r *KeyResolver) TryDecrypt(encryptedData string) (string, string, error) {
 	keys, err := r.ResolveKeys()
 	if err != nil {
@@ -64,6 +126,23 @@ func (r *KeyResolver) TryDecrypt(encryptedData string) (string, string, error) {
 	return "", "", fmt.Errorf("%w: failed to decrypt data with any available key", ErrDecryptionFailed)
 }
 
+// resolveSource returns the key provided by the source, or an empty string if it is unavailable
+func (r *KeyResolver) resolveSource(source KeySource) string {
+	switch source.Type {
+	case envSource:
+		return r.fromEnvironment(source.Name)
+	case fileSource:
+		if key, err := r.fromFile(source.Path); err == nil {
+			return key
+		}
+	case commandSource:
+		if key, err := runSourceCommand(source.Command, source.Timeout, source.Environment); err == nil {
+			return key
+		}
+	}
+	return ""
+}
+
 func (r *KeyResolver) fromEnvironment(envVar string) string {
 	if envVar == "" {
 		envVar = DefaultVaultKeyEnv
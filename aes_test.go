@@ -1,6 +1,9 @@
 package vault_test
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -102,6 +105,104 @@ func TestAESKeyResolver(t *testing.T) {
 	}
 }
 
+func TestAESKeyResolverCommand(t *testing.T) {
+	testKey, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate test key: %v", err)
+	}
+	t.Setenv("TEST_AES_COMMAND_KEY", testKey)
+
+	// the command does not inherit the environment unless a variable is passed through explicitly
+	env := map[string]string{"KEY": "$TEST_AES_COMMAND_KEY"}
+	resolver := vault.NewKeyResolver([]vault.KeySource{
+		{Type: "command", Command: `echo "$TEST_AES_COMMAND_KEY"`},
+		{Type: "command", Command: `echo "$KEY"`, Environment: env},
+	})
+	keys, err := resolver.ResolveKeys()
+	if err != nil {
+		t.Fatalf("Failed to resolve keys from command: %v", err)
+	}
+	if len(keys) != 1 || keys[0] != testKey {
+		t.Errorf("Expected only the explicitly passed key to be resolved, got %v", keys)
+	}
+	if env["KEY"] != "$TEST_AES_COMMAND_KEY" {
+		t.Errorf("Expected the source environment not to be expanded in place, got %q", env["KEY"])
+	}
+
+	resolver = vault.NewKeyResolver([]vault.KeySource{
+		{Type: "command", Command: "sleep 5 && echo late", Timeout: "50ms"},
+		{Type: "command", Command: "echo failing >&2 && exit 1"},
+	})
+	if _, err := resolver.ResolveKeys(); !errors.Is(err, vault.ErrNoAccess) {
+		t.Errorf("Expected ErrNoAccess when commands time out or fail, got: %v", err)
+	}
+
+	cfg := &vault.AesConfig{
+		StoragePath: t.TempDir(),
+		KeySource:   []vault.KeySource{{Type: "command"}},
+	}
+	if err := cfg.Validate(); !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for command source without a command, got: %v", err)
+	}
+}
+
+func TestAESVaultCommandSourceRunsOnce(t *testing.T) {
+	tempDir := t.TempDir()
+	key, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	// each command records that it ran, so the test can count the runs per open
+	countingSource := func(name, key string) vault.KeySource {
+		return vault.KeySource{
+			Type:        "command",
+			Command:     `echo run >> "$COUNT_FILE" && echo "$KEY"`,
+			Environment: map[string]string{"COUNT_FILE": filepath.Join(tempDir, name), "KEY": key},
+		}
+	}
+	runs := func(name string) int {
+		data, _ := os.ReadFile(filepath.Join(tempDir, name))
+		return strings.Count(string(data), "run")
+	}
+	config := &vault.Config{
+		ID:   "command-once-test",
+		Type: vault.ProviderTypeAES256,
+		Aes: &vault.AesConfig{
+			StoragePath: tempDir,
+			KeySource:   []vault.KeySource{countingSource("first", key), countingSource("second", "")},
+		},
+	}
+
+	legacy, err := crypto.EncryptValue(key, "version: 2\nid: command-once-test\nsecrets: {}\n")
+	if err != nil {
+		t.Fatalf("Failed to encrypt state: %v", err)
+	}
+	vaultFile := filepath.Join(tempDir, "vault-command-once-test.enc")
+	if err := os.WriteFile(vaultFile, []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to write vault file: %v", err)
+	}
+
+	// the legacy file is opened, then rewritten with the format header and opened again
+	for i := 1; i <= 2; i++ {
+		v, err := vault.NewAES256Vault(config)
+		if err != nil {
+			t.Fatalf("Failed to open vault: %v", err)
+		}
+		if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+			t.Fatalf("Failed to set secret: %v", err)
+		}
+		_ = v.Close()
+
+		if got := runs("first"); got != i {
+			t.Errorf("Expected the first command to run once per open, got %d runs after %d opens", got, i)
+		}
+		if got := runs("second"); got != 0 {
+			t.Errorf("Expected the second command not to run once the first key works, got %d runs", got)
+		}
+	}
+}
+
 func TestAESKeyResolverDecryption(t *testing.T) {
 	// Generate test keys
 	workingKey, err := vault.GenerateEncryptionKey()
@@ -305,6 +406,10 @@ func TestAESVaultFileFormat(t *testing.T) {
 		t.Error("Vault file should not be empty")
 	}
 
+	if !strings.HasPrefix(string(data), "FLOWVLT\x00") {
+		t.Error("Vault file should start with the format header")
+	}
+
 	// Verify the file is encrypted (should not contain plain text)
 	dataStr := string(data)
 	if strings.Contains(dataStr, "key1") ||
@@ -352,3 +457,487 @@ func TestAESDefaultKeySource(t *testing.T) {
 		t.Errorf("Expected key %s, got %s", testKey, keys[0])
 	}
 }
+
+func TestAESVaultRotateKey(t *testing.T) {
+	tempDir := t.TempDir()
+
+	oldKey, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate old key: %v", err)
+	}
+	newKey, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate new key: %v", err)
+	}
+	t.Setenv("ROTATE_OLD_KEY", oldKey)
+	t.Setenv("ROTATE_NEW_KEY", newKey)
+
+	config := &vault.Config{
+		ID:   "rotate-test",
+		Type: vault.ProviderTypeAES256,
+		Aes: &vault.AesConfig{
+			StoragePath: tempDir,
+			KeySource: []vault.KeySource{
+				{Type: "env", Name: "ROTATE_NEW_KEY"},
+				{Type: "env", Name: "ROTATE_OLD_KEY"},
+			},
+		},
+	}
+
+	// create the vault with only the old key available
+	t.Setenv("ROTATE_NEW_KEY", "")
+	v, err := vault.NewAES256Vault(config)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("rotated", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	source, err := v.ActiveKeySource()
+	if err != nil {
+		t.Fatalf("Failed to get active key source: %v", err)
+	}
+	if source.Name != "ROTATE_OLD_KEY" {
+		t.Errorf("Expected ROTATE_OLD_KEY to be active, got %s", source.Name)
+	}
+
+	if err := v.RotateKey("invalid-key"); err == nil {
+		t.Error("Expected an invalid key to be rejected")
+	}
+	if err := v.RotateKey(newKey); err != nil {
+		t.Fatalf("Failed to rotate key: %v", err)
+	}
+	if _, err := v.ActiveKeySource(); err == nil {
+		t.Error("Expected no active key source until the new key is configured")
+	}
+	_ = v.Close()
+
+	// the old key alone can no longer open the vault
+	config.Aes.KeySource = []vault.KeySource{{Type: "env", Name: "ROTATE_OLD_KEY"}}
+	if _, err := vault.NewAES256Vault(config); err == nil {
+		t.Error("Expected the old key to be rejected after rotation")
+	}
+
+	t.Setenv("ROTATE_NEW_KEY", newKey)
+	config.Aes.KeySource = []vault.KeySource{
+		{Type: "env", Name: "ROTATE_OLD_KEY"},
+		{Type: "env", Name: "ROTATE_NEW_KEY"},
+	}
+	v, err = vault.NewAES256Vault(config)
+	if err != nil {
+		t.Fatalf("Failed to open rotated vault: %v", err)
+	}
+	defer v.Close()
+
+	secret, err := v.GetSecret("rotated")
+	if err != nil {
+		t.Fatalf("Failed to get secret after rotation: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+	source, err = v.ActiveKeySource()
+	if err != nil {
+		t.Fatalf("Failed to get active key source: %v", err)
+	}
+	if source.Name != "ROTATE_NEW_KEY" {
+		t.Errorf("Expected ROTATE_NEW_KEY to be active, got %s", source.Name)
+	}
+}
+
+func TestAESVaultEnvelopeKeys(t *testing.T) {
+	tempDir := t.TempDir()
+
+	ownerKey, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate owner key: %v", err)
+	}
+	teammateKey, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate teammate key: %v", err)
+	}
+	t.Setenv("OWNER_KEY", ownerKey)
+	t.Setenv("TEAMMATE_KEY", teammateKey)
+
+	ownerConfig := &vault.Config{
+		ID:   "envelope-test",
+		Type: vault.ProviderTypeAES256,
+		Aes: &vault.AesConfig{
+			StoragePath: tempDir,
+			KeySource:   []vault.KeySource{{Type: "env", Name: "OWNER_KEY"}},
+		},
+	}
+	teammateConfig := &vault.Config{
+		ID:   "envelope-test",
+		Type: vault.ProviderTypeAES256,
+		Aes: &vault.AesConfig{
+			StoragePath: tempDir,
+			KeySource:   []vault.KeySource{{Type: "env", Name: "TEAMMATE_KEY"}},
+		},
+	}
+
+	v, err := vault.NewAES256Vault(ownerConfig)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("shared", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	km, ok := vault.HasKeyManagement(v)
+	if !ok {
+		t.Fatal("Expected AES vault to support key management")
+	}
+	if err := km.AddKey(teammateKey); err != nil {
+		t.Fatalf("Failed to add teammate key: %v", err)
+	}
+	keys, _ := km.ListKeys()
+	if len(keys) != 2 || keys[1] != vault.KeyID(teammateKey) {
+		t.Errorf("Expected owner and teammate key IDs, got %v", keys)
+	}
+	_ = v.Close()
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "vault-envelope-test.enc"))
+	if err != nil {
+		t.Fatalf("Failed to read vault file: %v", err)
+	}
+	if strings.Contains(string(data), ownerKey) || strings.Contains(string(data), teammateKey) {
+		t.Error("Vault file should not contain key-encryption keys")
+	}
+
+	teammateVault, err := vault.NewAES256Vault(teammateConfig)
+	if err != nil {
+		t.Fatalf("Teammate failed to open vault: %v", err)
+	}
+	secret, err := teammateVault.GetSecret("shared")
+	if err != nil {
+		t.Fatalf("Teammate failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+	_ = teammateVault.Close()
+
+	v, err = vault.NewAES256Vault(ownerConfig)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault: %v", err)
+	}
+	defer v.Close()
+	if err := v.RemoveKey(vault.KeyID(teammateKey)); err != nil {
+		t.Fatalf("Failed to remove teammate key: %v", err)
+	}
+	if err := v.RemoveKey(vault.KeyID(ownerKey)); err == nil {
+		t.Error("Expected removing the last key to fail")
+	}
+
+	if _, err := vault.NewAES256Vault(teammateConfig); err == nil {
+		t.Error("Expected removed key to be unable to open the vault")
+	}
+}
+
+func TestAESVaultPassphrase(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping scrypt key derivation in short mode")
+	}
+	tempDir := t.TempDir()
+	passFile := filepath.Join(tempDir, "passphrase")
+	if err := os.WriteFile(passFile, []byte("correct horse battery staple\n"), 0600); err != nil {
+		t.Fatalf("Failed to write passphrase file: %v", err)
+	}
+
+	config := &vault.Config{
+		ID:   "passphrase-test",
+		Type: vault.ProviderTypeAES256,
+		Aes: &vault.AesConfig{
+			StoragePath: tempDir,
+			KeySource:   []vault.KeySource{{Type: "passphrase", Path: passFile}},
+		},
+	}
+
+	v, err := vault.NewAES256Vault(config)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	source, err := v.ActiveKeySource()
+	if err != nil || source.Type != "passphrase" {
+		t.Errorf("Expected passphrase source to be active, got %+v, %v", source, err)
+	}
+	_ = v.Close()
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "vault-passphrase-test.enc"))
+	if err != nil {
+		t.Fatalf("Failed to read vault file: %v", err)
+	}
+	if !strings.Contains(string(data), `"salt"`) || !strings.Contains(string(data), `"scrypt"`) {
+		t.Error("Expected vault file to record the key derivation parameters")
+	}
+
+	v, err = vault.NewAES256Vault(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault with passphrase: %v", err)
+	}
+	secret, err := v.GetSecret("key")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+	_ = v.Close()
+
+	if err := os.WriteFile(passFile, []byte("wrong passphrase"), 0600); err != nil {
+		t.Fatalf("Failed to write passphrase file: %v", err)
+	}
+	if _, err := vault.NewAES256Vault(config); err == nil {
+		t.Error("Expected wrong passphrase to fail to open the vault")
+	}
+}
+
+func TestAESVaultFileMigration(t *testing.T) {
+	key, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	t.Setenv("MIGRATION_KEY", key)
+
+	state := "version: 2\nid: migration-test\nsecrets:\n  key:\n    value: value\n"
+	headerless, err := crypto.EncryptValue(key, state)
+	if err != nil {
+		t.Fatalf("Failed to encrypt state: %v", err)
+	}
+	dek, _ := crypto.GenerateKey()
+	wrapped, _ := crypto.EncryptValue(key, dek)
+	data, _ := crypto.EncryptValue(dek, state)
+	envelope := fmt.Sprintf(`{"version":1,"keys":[{"id":%q,"wrapped":%q}],"data":%q}`, vault.KeyID(key), wrapped, data)
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "headerless", file: headerless},
+		{name: "json envelope", file: envelope},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			vaultFile := filepath.Join(tempDir, "vault-migration-test.enc")
+			if err := os.WriteFile(vaultFile, []byte(tt.file), 0600); err != nil {
+				t.Fatalf("Failed to write vault file: %v", err)
+			}
+
+			v, err := vault.NewAES256Vault(&vault.Config{
+				ID:   "migration-test",
+				Type: vault.ProviderTypeAES256,
+				Aes: &vault.AesConfig{
+					StoragePath: tempDir,
+					KeySource:   []vault.KeySource{{Type: "env", Name: "MIGRATION_KEY"}},
+				},
+			})
+			if err != nil {
+				t.Fatalf("Failed to open vault: %v", err)
+			}
+			defer v.Close()
+
+			secret, err := v.GetSecret("key")
+			if err != nil || secret.PlainTextString() != "value" {
+				t.Fatalf("Expected migrated secret 'value', got %v, %v", secret, err)
+			}
+			if err := v.SetSecret("other", vault.NewSecretValue([]byte("value"))); err != nil {
+				t.Fatalf("Failed to set secret: %v", err)
+			}
+
+			written, _ := os.ReadFile(vaultFile)
+			if !strings.HasPrefix(string(written), "FLOWVLT\x00") {
+				t.Error("Expected vault file to be rewritten with the format header")
+			}
+		})
+	}
+}
+
+func TestAESVaultRejectsOversizedKDF(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("OVERSIZED_PASSPHRASE", "correct horse battery staple")
+
+	kdfs := map[string]string{
+		"scrypt":   `{"alg":"scrypt","salt":"c2FsdA==","scrypt":{"n":1073741824,"r":8,"p":1}}`,
+		"argon2id": `{"alg":"argon2id","salt":"c2FsdA==","argon2id":{"t":1,"m":4294967295,"p":1}}`,
+	}
+	for name, kdf := range kdfs {
+		t.Run(name, func(t *testing.T) {
+			envelope := fmt.Sprintf(`{"version":1,"keys":[{"id":"slot","wrapped":"AAAA","kdf":%s}],"data":"AAAA"}`, kdf)
+			vaultFile := filepath.Join(tempDir, "vault-oversized-"+name+".enc")
+			if err := os.WriteFile(vaultFile, []byte(envelope), 0600); err != nil {
+				t.Fatalf("Failed to write vault file: %v", err)
+			}
+
+			_, err := vault.NewAES256Vault(&vault.Config{
+				ID:   "oversized-" + name,
+				Type: vault.ProviderTypeAES256,
+				Aes: &vault.AesConfig{
+					StoragePath: tempDir,
+					KeySource:   []vault.KeySource{{Type: "passphrase", Name: "OVERSIZED_PASSPHRASE"}},
+				},
+			})
+			if !errors.Is(err, vault.ErrDecryptionFailed) {
+				t.Errorf("Expected ErrDecryptionFailed for oversized KDF parameters, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestAESVaultRejectsSwappedFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	key, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	t.Setenv("SWAP_KEY", key)
+
+	newConfig := func(id string) *vault.Config {
+		return &vault.Config{
+			ID:   id,
+			Type: vault.ProviderTypeAES256,
+			Aes: &vault.AesConfig{
+				StoragePath: tempDir,
+				KeySource:   []vault.KeySource{{Type: "env", Name: "SWAP_KEY"}},
+			},
+		}
+	}
+
+	for _, id := range []string{"first", "second"} {
+		v, err := vault.NewAES256Vault(newConfig(id))
+		if err != nil {
+			t.Fatalf("Failed to create vault %s: %v", id, err)
+		}
+		_ = v.SetSecret("owner", vault.NewSecretValue([]byte(id)))
+		_ = v.Close()
+	}
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "vault-second.enc"))
+	if err != nil {
+		t.Fatalf("Failed to read vault file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "vault-first.enc"), data, 0600); err != nil {
+		t.Fatalf("Failed to overwrite vault file: %v", err)
+	}
+
+	if _, err := vault.NewAES256Vault(newConfig("first")); !errors.Is(err, vault.ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for swapped vault file, got: %v", err)
+	}
+
+	// files written before the vault ID was bound into the ciphertext are checked against the decrypted state
+	legacy, err := crypto.EncryptValue(key, "version: 2\nid: second\nsecrets: {}\n")
+	if err != nil {
+		t.Fatalf("Failed to encrypt state: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "vault-first.enc"), []byte(legacy), 0600); err != nil {
+		t.Fatalf("Failed to overwrite vault file: %v", err)
+	}
+	if _, err := vault.NewAES256Vault(newConfig("first")); !errors.Is(err, vault.ErrDecryptionFailed) {
+		t.Errorf("Expected ErrDecryptionFailed for swapped legacy vault file, got: %v", err)
+	}
+}
+
+func TestAESVaultPassphraseArgon2id(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("ARGON2_PASSPHRASE", "correct horse battery staple")
+
+	opts := []vault.Option{
+		vault.WithProvider(vault.ProviderTypeAES256),
+		vault.WithAESPath(tempDir),
+		vault.WithAESPassphraseFromEnv("ARGON2_PASSPHRASE"),
+		vault.WithAESKDF(crypto.KDFParams{
+			Algorithm: crypto.KDFArgon2id,
+			Argon2id:  &crypto.Argon2Params{Time: 1, Memory: 8 * 1024, Threads: 1},
+		}),
+	}
+
+	v, _, err := vault.New("argon2-test", opts...)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	_ = v.Close()
+
+	data, err := os.ReadFile(filepath.Join(tempDir, "vault-argon2-test.enc"))
+	if err != nil {
+		t.Fatalf("Failed to read vault file: %v", err)
+	}
+	if !strings.Contains(string(data), `"argon2id"`) {
+		t.Error("Expected vault file to record the argon2id parameters")
+	}
+
+	// the recorded parameters are used to unlock the vault, regardless of the configured KDF
+	v, _, err = vault.New("argon2-test",
+		vault.WithProvider(vault.ProviderTypeAES256),
+		vault.WithAESPath(tempDir),
+		vault.WithAESPassphraseFromEnv("ARGON2_PASSPHRASE"),
+	)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault: %v", err)
+	}
+	defer v.Close()
+
+	secret, err := v.GetSecret("key")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+}
+
+func TestAESVaultLargeBinarySecret(t *testing.T) {
+	tempDir := t.TempDir()
+
+	key, err := vault.GenerateEncryptionKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	t.Setenv("LARGE_SECRET_KEY", key)
+
+	config := &vault.Config{
+		ID:   "large-test",
+		Type: vault.ProviderTypeAES256,
+		Aes: &vault.AesConfig{
+			StoragePath: tempDir,
+			KeySource:   []vault.KeySource{{Type: "env", Name: "LARGE_SECRET_KEY"}},
+		},
+	}
+
+	blob := make([]byte, 4*1024*1024)
+	for i := range blob {
+		blob[i] = byte(i % 251)
+	}
+
+	v, err := vault.NewAES256Vault(config)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("blob", vault.NewSecretValue(blob)); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	_ = v.Close()
+
+	v, err = vault.NewAES256Vault(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault: %v", err)
+	}
+	defer v.Close()
+
+	secret, err := v.GetSecret("blob")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if !bytes.Equal(secret.Bytes(), blob) {
+		t.Error("Expected binary secret to round-trip unchanged")
+	}
+}
@@ -2,18 +2,22 @@ package vault
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
 	"filippo.io/age"
+	"filippo.io/age/armor"
 )
 
 const (
-	ageCurrentVaultVersion = 1
+	ageCurrentVaultVersion = 2
 	ageVaultFileExt        = "age"
 )
 
@@ -21,6 +25,18 @@ const (
 type AgeState struct {
 	Metadata `json:"metadata"`
 
+	Version    int                     `json:"version"`
+	ID         string                  `json:"id"`
+	Recipients []string                `json:"recipients"`
+	Secrets    map[string]*SecretEntry `json:"secrets"`
+	// PerSecret records that each secret value is encrypted on its own
+	PerSecret bool `json:"perSecret,omitempty"`
+}
+
+// ageStateV1 is the version 1 layout of AgeState, in which secrets were stored as bare values.
+type ageStateV1 struct {
+	Metadata `json:"metadata"`
+
 	Version    int               `json:"version"`
 	ID         string            `json:"id"`
 	Recipients []string          `json:"recipients"`
@@ -45,6 +61,9 @@ func NewAgeVault(cfg *Config) (*AgeVault, error) {
 	if cfg.Age == nil {
 		return nil, fmt.Errorf("age configuration is required")
 	}
+	if err := validateHistorySize(cfg.Age.HistorySize); err != nil {
+		return nil, err
+	}
 
 	path := filepath.Join(
 		filepath.Clean(cfg.Age.StoragePath),
@@ -58,12 +77,19 @@ func NewAgeVault(cfg *Config) (*AgeVault, error) {
 		cfg:      cfg.Age,
 		resolver: NewIdentityResolver(cfg.Age.IdentitySources),
 	}
+	vault.resolver.pluginUI = cfg.Age.PluginUI
 
-	ids, err := vault.resolver.ResolveIdentities()
-	if err != nil {
-		return nil, fmt.Errorf("failed to resolve identities: %w", err)
+	if vault.passphraseMode() {
+		if err := vault.usePassphrase(); err != nil {
+			return nil, err
+		}
+	} else {
+		ids, err := vault.resolver.ResolveIdentities()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve identities: %w", err)
+		}
+		vault.identities = ids
 	}
-	vault.identities = ids
 
 	if err := vault.load(); err != nil {
 		return nil, fmt.Errorf("failed to load vault: %w", err)
@@ -88,9 +114,16 @@ func (v *AgeVault) init() error {
 			LastModified: now,
 		},
 		Recipients: v.cfg.Recipients,
-		Secrets:    make(map[string]string),
+		Secrets:    make(map[string]*SecretEntry),
+		PerSecret:  v.cfg.PerSecretEncryption,
+	}
+	if v.passphraseMode() {
+		return v.save()
 	}
 
+	if _, err := v.syncRecipientsFile(); err != nil {
+		return err
+	}
 	for _, recipientKey := range v.cfg.Recipients {
 		if err := v.addRecipientToState(recipientKey); err != nil {
 			return fmt.Errorf("failed to add initial recipient %s: %w", recipientKey, err)
@@ -122,30 +155,97 @@ func (v *AgeVault) load() error {
 		return nil
 	}
 
-	r, err := age.Decrypt(bytes.NewReader(data), v.identities...)
+	r, err := age.Decrypt(ageCiphertextReader(data), v.identities...)
 	if err != nil {
 		return fmt.Errorf("failed to decrypt vault file - do you have the right key?: %w", err)
 	}
 
-	var state AgeState
-	if err := json.NewDecoder(r).Decode(&state); err != nil {
-		return fmt.Errorf("failed to unmarshal vault state: %w", err)
+	state, err := decodeAgeState(r)
+	if err != nil {
+		return err
 	}
 
-	v.state = &state
-	if err := v.parseRecipients(); err != nil {
-		return fmt.Errorf("failed to parse recipients: %w", err)
+	v.state = state
+	previous := v.state.Recipients
+	changed, err := v.syncRecipientsFile()
+	if err != nil {
+		return err
+	}
+	if changed {
+		err = v.recipientsChanged(previous)
+	} else {
+		err = v.parseRecipients()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update recipients: %w", err)
+	}
+	converted, err := v.convertSecretEncryption()
+	if err != nil {
+		return err
+	}
+	if changed || converted {
+		// re-encrypt so that only the recipients in the recipients file can decrypt the vault
+		if err := v.save(); err != nil {
+			return fmt.Errorf("failed to re-encrypt vault for updated recipients: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// decodeAgeState unmarshals the decrypted vault state, migrating it from older versions when needed.
+func decodeAgeState(r io.Reader) (*AgeState, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vault state: %w", err)
+	}
+
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault state: %w", err)
+	}
+
+	switch {
+	case probe.Version > ageCurrentVaultVersion:
+		return nil, fmt.Errorf("unsupported vault version %d", probe.Version)
+	case probe.Version <= 1:
+		var legacy ageStateV1
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal vault state: %w", err)
+		}
+		return &AgeState{
+			Metadata:   legacy.Metadata,
+			Version:    ageCurrentVaultVersion,
+			ID:         legacy.ID,
+			Recipients: legacy.Recipients,
+			Secrets:    migrateSecretsV1(legacy.Secrets, legacy.LastModified),
+		}, nil
+	}
+
+	var state AgeState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal vault state: %w", err)
+	}
+	return &state, nil
+}
+
 // save encrypts and writes the vault contents to disk
 func (v *AgeVault) save() error {
 	if v.state == nil {
 		return nil
 	}
 
+	previous := v.state.Recipients
+	if changed, err := v.syncRecipientsFile(); err != nil {
+		return err
+	} else if changed {
+		if err := v.recipientsChanged(previous); err != nil {
+			return err
+		}
+	}
+
 	if len(v.recipients) == 0 {
 		return fmt.Errorf("no recipients available for encryption")
 	}
@@ -157,8 +257,15 @@ func (v *AgeVault) save() error {
 	}
 
 	var buf bytes.Buffer
+	var dst io.Writer = &buf
+	var armorWriter io.WriteCloser
+	if v.cfg.Armor {
+		armorWriter = armor.NewWriter(&buf)
+		dst = armorWriter
+	}
+
 	// encrypt the entire file using age
-	w, err := age.Encrypt(&buf, v.recipients...)
+	w, err := age.Encrypt(dst, v.recipients...)
 	if err != nil {
 		return fmt.Errorf("failed to create age encryptor: %w", err)
 	}
@@ -168,6 +275,11 @@ func (v *AgeVault) save() error {
 	if err := w.Close(); err != nil {
 		return fmt.Errorf("failed to finalize encryption: %w", err)
 	}
+	if armorWriter != nil {
+		if err := armorWriter.Close(); err != nil {
+			return fmt.Errorf("failed to finalize armor: %w", err)
+		}
+	}
 
 	// write to the file atomically
 	if err := os.MkdirAll(filepath.Dir(v.fullPath), 0750); err != nil {
@@ -201,37 +313,104 @@ func (v *AgeVault) Metadata() Metadata {
 }
 
 func (v *AgeVault) GetSecret(key string) (Secret, error) {
+	return v.GetSecretContext(context.Background(), key)
+}
+
+func (v *AgeVault) GetSecretContext(ctx context.Context, key string) (Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
-	value, exists := v.state.Secrets[key]
+	entry, exists := v.state.Secrets[key]
 	if !exists {
 		return nil, ErrSecretNotFound
 	}
+	if entry.expired() {
+		return nil, ErrSecretExpired
+	}
 
+	value, err := v.readValue(entry.Value)
+	if err != nil {
+		return nil, err
+	}
 	return NewSecretValue([]byte(value)), nil
 }
 
 func (v *AgeVault) SetSecret(key string, value Secret) error {
+	return v.SetSecretContext(context.Background(), key, value)
+}
+
+func (v *AgeVault) SetSecretContext(ctx context.Context, key string, value Secret) error {
+	return v.setSecret(ctx, key, value, SetOptions{})
+}
+
+func (v *AgeVault) SetSecretWithOptions(key string, value Secret, opts SetOptions) error {
+	return v.setSecret(context.Background(), key, value, opts)
+}
+
+func (v *AgeVault) setSecret(ctx context.Context, key string, value Secret, opts SetOptions) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if err := ValidateSecretKey(key); err != nil {
 		return err
 	}
 
 	if v.state.Secrets == nil {
-		v.state.Secrets = make(map[string]string)
+		v.state.Secrets = make(map[string]*SecretEntry)
+	}
+
+	entry, exists := v.state.Secrets[key]
+	recipients := slices.Clone(opts.Recipients)
+	if len(recipients) > 0 {
+		if !v.state.PerSecret {
+			return v.errPerSecretDisabled()
+		}
+		if err := v.validateSecretRecipients(recipients); err != nil {
+			return err
+		}
+	} else if exists {
+		recipients = entry.Recipients
 	}
 
-	v.state.Secrets[key] = value.PlainTextString()
+	stored, err := v.writeValue(value.PlainTextString(), recipients)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if err := v.setEntryRecipients(entry, recipients); err != nil {
+			return err
+		}
+		entry.setValue(stored, v.cfg.HistorySize)
+		entry.Metadata.NeedsRotation = false
+	} else {
+		entry = newSecretEntry(stored)
+		entry.Recipients = recipients
+		v.state.Secrets[key] = entry
+	}
+	entry.Metadata.ExpiresAt = opts.expiresAt()
 	return v.save()
 }
 
 func (v *AgeVault) DeleteSecret(key string) error {
+	return v.DeleteSecretContext(context.Background(), key)
+}
+
+func (v *AgeVault) DeleteSecretContext(ctx context.Context, key string) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	_, exists := v.state.Secrets[key]
 	if !exists {
 		return ErrSecretNotFound
@@ -242,6 +421,14 @@ func (v *AgeVault) DeleteSecret(key string) error {
 }
 
 func (v *AgeVault) ListSecrets() ([]string, error) {
+	return v.ListSecretsContext(context.Background())
+}
+
+func (v *AgeVault) ListSecretsContext(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -253,6 +440,14 @@ func (v *AgeVault) ListSecrets() ([]string, error) {
 }
 
 func (v *AgeVault) HasSecret(key string) (bool, error) {
+	return v.HasSecretContext(context.Background(), key)
+}
+
+func (v *AgeVault) HasSecretContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -260,6 +455,97 @@ func (v *AgeVault) HasSecret(key string) (bool, error) {
 	return exists, nil
 }
 
+func (v *AgeVault) GetSecretMetadata(key string) (SecretMetadata, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return SecretMetadata{}, ErrSecretNotFound
+	}
+
+	return entry.Metadata.copy(), nil
+}
+
+func (v *AgeVault) ListSecretsWithMetadata() (map[string]SecretMetadata, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return secretsMetadata(v.state.Secrets), nil
+}
+
+func (v *AgeVault) SetSecretMetadata(key, description string, tags []string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	entry.Metadata.Description = description
+	entry.Metadata.Tags = append([]string(nil), tags...)
+	return v.save()
+}
+
+func (v *AgeVault) GetSecretVersion(key string, version int) (Secret, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+
+	stored, err := entry.readVersion(key, version)
+	if err != nil {
+		return nil, err
+	}
+	value, err := v.readValue(stored)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretValue([]byte(value)), nil
+}
+
+func (v *AgeVault) ListSecretVersions(key string) ([]SecretVersionInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+
+	return entry.versions(), nil
+}
+
+func (v *AgeVault) RollbackSecret(key string, version int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	if err := entry.rollback(key, version, v.cfg.HistorySize); err != nil {
+		return err
+	}
+	return v.save()
+}
+
+func (v *AgeVault) PurgeExpired() ([]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	purged := purgeExpired(v.state.Secrets)
+	if len(purged) == 0 {
+		return purged, nil
+	}
+	return purged, v.save()
+}
+
 func (v *AgeVault) Close() error {
 	// clear the secret state from memory
 	v.mu.Lock()
@@ -273,49 +559,93 @@ func (v *AgeVault) Close() error {
 }
 
 func (v *AgeVault) AddRecipient(publicKey string) error {
+	if v.passphraseMode() {
+		return v.errPassphraseMode()
+	}
+	if v.cfg.RecipientsFile != "" {
+		return v.errRecipientsFile()
+	}
+
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	previous := slices.Clone(v.state.Recipients)
+	restore := v.snapshotRecipients()
 	if err := v.addRecipientToState(publicKey); err != nil {
 		return err
 	}
-	if err := v.parseRecipients(); err != nil {
-		return fmt.Errorf("failed to parse recipients: %w", err)
+	if err := v.recipientsChanged(previous); err != nil {
+		restore()
+		return err
 	}
-
-	return v.save()
+	if err := v.save(); err != nil {
+		restore()
+		return err
+	}
+	return nil
 }
 
 func (v *AgeVault) RemoveRecipient(publicKey string) error {
+	_, err := v.RemoveRecipientWithReport(publicKey)
+	return err
+}
+
+func (v *AgeVault) RemoveRecipientWithReport(publicKey string) (RecipientRemovalReport, error) {
+	report := RecipientRemovalReport{Recipient: publicKey}
+	if v.passphraseMode() {
+		return report, v.errPassphraseMode()
+	}
+	if v.cfg.RecipientsFile != "" {
+		return report, v.errRecipientsFile()
+	}
+
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
 	// Don't allow removing the last recipient
 	if len(v.state.Recipients) <= 1 {
-		return fmt.Errorf("cannot remove the last recipient - at least one recipient is required for encryption")
+		return report, fmt.Errorf(
+			"cannot remove the last recipient - at least one recipient is required for encryption",
+		)
 	}
 
-	found := false
-	for i, rec := range v.state.Recipients {
-		if rec == publicKey {
-			v.state.Recipients = append(v.state.Recipients[:i], v.state.Recipients[i+1:]...)
-			found = true
-			break
-		}
+	i := slices.Index(v.state.Recipients, publicKey)
+	if i < 0 {
+		return report, fmt.Errorf("recipient %s not found", publicKey)
 	}
+	exposed := v.readableBy(publicKey)
 
-	if !found {
-		return fmt.Errorf("recipient %s not found", publicKey)
+	previous := v.state.Recipients
+	restore := v.snapshotRecipients()
+	v.state.Recipients = slices.Delete(slices.Clone(previous), i, i+1)
+	if err := v.recipientsChanged(previous); err != nil {
+		restore()
+		return report, err
 	}
 
-	if err := v.parseRecipients(); err != nil {
-		return fmt.Errorf("failed to parse recipients: %w", err)
+	for _, key := range exposed {
+		v.state.Secrets[key].Metadata.NeedsRotation = true
+	}
+	if err := v.save(); err != nil {
+		restore()
+		return report, err
 	}
+	report.Secrets = exposed
+	return report, nil
+}
 
-	return v.save()
+func (v *AgeVault) ListPendingRotations() ([]string, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return pendingRotations(v.state.Secrets), nil
 }
 
 func (v *AgeVault) ListRecipients() ([]string, error) {
+	if v.passphraseMode() {
+		return nil, v.errPassphraseMode()
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -1,15 +1,28 @@
 package vault
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"filippo.io/age"
+	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"filippo.io/age/plugin"
+)
+
+const (
+	// ageFileHeader is the first line of a binary age encrypted file
+	ageFileHeader = "age-encryption.org/"
+	// pluginIdentityPrefix is the prefix of identities handled by an age plugin
+	pluginIdentityPrefix = "AGE-PLUGIN-"
 )
 
 type IdentityResolver struct {
-	sources []IdentitySource
+	sources  []IdentitySource
+	pluginUI *plugin.ClientUI
 }
 
 func NewIdentityResolver(sources []IdentitySource) *IdentityResolver {
@@ -27,15 +40,19 @@ func (r *IdentityResolver) ResolveIdentities() ([]age.Identity, error) {
 	for _, source := range r.sources {
 		switch source.Type {
 		case envSource:
-			if id := r.fromEnvironment(source.Name); id != nil {
-				identities = append(identities, id)
-			}
+			identities = append(identities, r.fromEnvironment(source.Name)...)
 		case fileSource:
-			if id, err := r.fromFile(source.Path); err != nil {
+			ids, err := r.fromFile(source.Path, source.Passphrase)
+			if err != nil {
 				return nil, fmt.Errorf("failed to read identity from file %s: %w", source.Path, err)
-			} else if id != nil {
-				identities = append(identities, id)
 			}
+			identities = append(identities, ids...)
+		case commandSource:
+			ids, err := r.fromCommand(source)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read identity from command: %w", err)
+			}
+			identities = append(identities, ids...)
 		}
 	}
 
@@ -46,7 +63,7 @@ func (r *IdentityResolver) ResolveIdentities() ([]age.Identity, error) {
 	return identities, nil
 }
 
-func (r *IdentityResolver) fromEnvironment(envVar string) age.Identity {
+func (r *IdentityResolver) fromEnvironment(envVar string) []age.Identity {
 	if envVar == "" {
 		envVar = DefaultVaultKeyEnv
 	}
@@ -56,15 +73,15 @@ func (r *IdentityResolver) fromEnvironment(envVar string) age.Identity {
 		return nil
 	}
 
-	identity, err := age.ParseX25519Identity(keyStr)
+	identities, err := parseIdentities([]byte(keyStr), r.pluginUI)
 	if err != nil {
 		return nil
 	}
 
-	return identity
+	return identities
 }
 
-func (r *IdentityResolver) fromFile(path string) (age.Identity, error) {
+func (r *IdentityResolver) fromFile(path string, passphrase *PassphraseSource) ([]age.Identity, error) {
 	if path == "" {
 		return nil, fmt.Errorf("identity file path cannot be empty")
 	}
@@ -79,16 +96,169 @@ func (r *IdentityResolver) fromFile(path string) (age.Identity, error) {
 		return nil, fmt.Errorf("failed to read identity file %s: %w", expandedPath, err)
 	}
 
-	identity, err := age.ParseX25519Identity(strings.TrimSpace(string(keyBytes)))
+	if isAgeEncrypted(keyBytes) {
+		if keyBytes, err = decryptIdentityFile(keyBytes, passphrase); err != nil {
+			return nil, fmt.Errorf("failed to decrypt identity file %s: %w", expandedPath, err)
+		}
+	}
+
+	identities, err := parseIdentities(keyBytes, r.pluginUI)
 	if err != nil {
 		return nil, fmt.Errorf("invalid identity in file %s: %w", expandedPath, err)
 	}
 
-	return identity, nil
+	return identities, nil
+}
+
+func (r *IdentityResolver) fromCommand(source IdentitySource) ([]age.Identity, error) {
+	output, err := runSourceCommand(source.Command, source.Timeout, source.Environment)
+	if err != nil {
+		return nil, err
+	}
+
+	identities, err := parseIdentities([]byte(output), r.pluginUI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid identity in command output: %w", err)
+	}
+
+	return identities, nil
+}
+
+// isAgeEncrypted reports whether the data is an age encrypted file, in binary or armored form
+func isAgeEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(ageFileHeader)) ||
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header))
+}
+
+// ageCiphertextReader returns a reader for the binary age ciphertext of an age encrypted file, removing the
+// armor if the file is armored
+func ageCiphertextReader(data []byte) io.Reader {
+	if bytes.HasPrefix(data, []byte(ageFileHeader)) {
+		return bytes.NewReader(data)
+	}
+	return armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+}
+
+// decryptIdentityFile decrypts an identity file that was encrypted with a passphrase, e.g. by `age -p`
+func decryptIdentityFile(data []byte, passphrase *PassphraseSource) ([]byte, error) {
+	if passphrase == nil {
+		return nil, fmt.Errorf("%w: identity file is encrypted but no passphrase source is configured", ErrNoAccess)
+	}
+	pass, err := passphrase.resolve()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve passphrase: %w", err)
+	}
+	identity, err := age.NewScryptIdentity(pass)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create passphrase identity: %w", err)
+	}
+
+	r, err := age.Decrypt(ageCiphertextReader(data), identity)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	return io.ReadAll(r)
+}
+
+// parseIdentities parses an unencrypted SSH private key in PEM or OpenSSH format, or an age identity file with one
+// or more X25519 or plugin identities. Empty lines and lines starting with "#" are ignored in identity files.
+func parseIdentities(data []byte, ui *plugin.ClientUI) ([]age.Identity, error) {
+	if bytes.Contains(data, []byte("PRIVATE KEY-----")) {
+		identity, err := agessh.ParseIdentity(data)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	var identities []age.Identity
+	for n, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var identity age.Identity
+		var err error
+		if strings.HasPrefix(line, pluginIdentityPrefix) {
+			identity, err = plugin.NewIdentity(line, pluginClientUI(ui))
+		} else {
+			identity, err = age.ParseX25519Identity(line)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error at line %d: %w", n+1, err)
+		}
+		identities = append(identities, identity)
+	}
+
+	if len(identities) == 0 {
+		return nil, fmt.Errorf("no secret keys found")
+	}
+	return identities, nil
+}
+
+// parseRecipient parses an X25519 recipient, an ssh-ed25519 or ssh-rsa public key, or a plugin recipient. Plugin
+// recipients are handled by the age-plugin-<name> binary found in PATH.
+func parseRecipient(publicKey string, ui *plugin.ClientUI) (age.Recipient, error) {
+	if strings.HasPrefix(publicKey, "ssh-") {
+		return agessh.ParseRecipient(publicKey)
+	}
+
+	recipient, err := age.ParseX25519Recipient(publicKey)
+	if err == nil {
+		return recipient, nil
+	}
+	if _, _, pluginErr := plugin.ParseRecipient(publicKey); pluginErr != nil {
+		return nil, err
+	}
+	return plugin.NewRecipient(publicKey, pluginClientUI(ui))
+}
+
+// pluginClientUI returns the UI used to interact with age plugins. Plugins that need to prompt the user, e.g. for a
+// hardware token PIN, fail when no UI is configured.
+func pluginClientUI(ui *plugin.ClientUI) *plugin.ClientUI {
+	if ui == nil {
+		return &plugin.ClientUI{}
+	}
+	return ui
+}
+
+// usePassphrase sets up the vault to encrypt to and decrypt with a passphrase instead of recipients
+func (v *AgeVault) usePassphrase() error {
+	passphrase, err := v.cfg.Passphrase.resolve()
+	if err != nil {
+		return fmt.Errorf("failed to resolve passphrase: %w", err)
+	}
+	if passphrase == "" {
+		return fmt.Errorf("%w: no passphrase found", ErrNoAccess)
+	}
+
+	recipient, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to create passphrase recipient: %w", err)
+	}
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return fmt.Errorf("failed to create passphrase identity: %w", err)
+	}
+
+	v.recipients = []age.Recipient{recipient}
+	v.identities = []age.Identity{identity}
+	return nil
+}
+
+// passphraseMode reports whether the vault is encrypted with a passphrase instead of recipients
+func (v *AgeVault) passphraseMode() bool {
+	return v.cfg.Passphrase != nil
+}
+
+func (v *AgeVault) errPassphraseMode() error {
+	return fmt.Errorf("%w: recipients do not apply to passphrase-protected age vaults", ErrNotSupported)
 }
 
 func (v *AgeVault) addRecipientToState(publicKey string) error {
-	_, err := age.ParseX25519Recipient(publicKey)
+	publicKey = strings.TrimSpace(publicKey)
+	_, err := parseRecipient(publicKey, v.cfg.PluginUI)
 	if err != nil {
 		return fmt.Errorf("%w: invalid recipient key: %w", ErrInvalidRecipient, err)
 	}
@@ -104,10 +274,13 @@ func (v *AgeVault) addRecipientToState(publicKey string) error {
 }
 
 func (v *AgeVault) parseRecipients() error {
+	if v.passphraseMode() {
+		return nil // the passphrase recipient is set up when the vault is opened
+	}
 	v.recipients = make([]age.Recipient, 0, len(v.state.Recipients))
 
 	for _, recipientStr := range v.state.Recipients {
-		recipient, err := age.ParseX25519Recipient(recipientStr)
+		recipient, err := parseRecipient(recipientStr, v.cfg.PluginUI)
 		if err != nil {
 			return fmt.Errorf("%w: invalid recipient %s: %w", ErrInvalidRecipient, recipientStr, err)
 		}
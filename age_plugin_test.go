@@ -0,0 +1,132 @@
+package vault_test
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"filippo.io/age/plugin"
+
+	"github.com/flowexec/vault"
+)
+
+const fakePluginName = "fake"
+
+// runFakeAgePlugin implements the age plugin protocol for a plugin that "wraps" file keys by inverting their bits
+func runFakeAgePlugin(protocol string) {
+	in := bufio.NewReader(os.Stdin)
+	var fileKey []byte
+	for {
+		typ, args, body := readPluginStanza(in)
+		if typ == "done" {
+			break
+		}
+		switch {
+		case protocol == "recipient-v1" && typ == "wrap-file-key":
+			fileKey = body
+		case protocol == "identity-v1" && typ == "recipient-stanza" && len(args) > 1 && args[1] == fakePluginName:
+			fileKey = body
+		}
+	}
+
+	for i := range fileKey {
+		fileKey[i] ^= 0xff
+	}
+	if fileKey != nil {
+		if protocol == "recipient-v1" {
+			writePluginStanza("recipient-stanza 0 "+fakePluginName, fileKey)
+		} else {
+			writePluginStanza("file-key 0", fileKey)
+		}
+		readPluginStanza(in) // ok
+	}
+	writePluginStanza("done", nil)
+}
+
+func readPluginStanza(r *bufio.Reader) (string, []string, []byte) {
+	header, _ := r.ReadString('\n')
+	fields := strings.Fields(strings.TrimPrefix(header, "-> "))
+	var encoded string
+	for {
+		line, _ := r.ReadString('\n')
+		line = strings.TrimSuffix(line, "\n")
+		encoded += line
+		if len(line) < 64 {
+			break
+		}
+	}
+	body, _ := base64.RawStdEncoding.DecodeString(encoded)
+	if len(fields) == 0 {
+		return "", nil, nil
+	}
+	return fields[0], fields[1:], body
+}
+
+func writePluginStanza(header string, body []byte) {
+	encoded := base64.RawStdEncoding.EncodeToString(body)
+	_, _ = fmt.Fprintf(os.Stdout, "-> %s\n%s\n", header, encoded)
+}
+
+// installFakeAgePlugin makes the test binary available in PATH as the fake age plugin
+func installFakeAgePlugin(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("age plugins are not supported on Windows")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatalf("Failed to find test binary: %v", err)
+	}
+	dir := t.TempDir()
+	if err := os.Symlink(exe, filepath.Join(dir, "age-plugin-"+fakePluginName)); err != nil {
+		t.Fatalf("Failed to install fake plugin: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestAgeVaultPlugin(t *testing.T) {
+	installFakeAgePlugin(t)
+	tempDir := t.TempDir()
+
+	recipient := plugin.EncodeRecipient(fakePluginName, []byte("token-1"))
+	t.Setenv("PLUGIN_IDENTITY", plugin.EncodeIdentity(fakePluginName, []byte("token-1")))
+
+	config := &vault.Config{
+		ID:   "plugin-test",
+		Type: vault.ProviderTypeAge,
+		Age: &vault.AgeConfig{
+			StoragePath:     tempDir,
+			IdentitySources: []vault.IdentitySource{{Type: "env", Name: "PLUGIN_IDENTITY"}},
+			Recipients:      []string{recipient},
+		},
+	}
+
+	v, err := vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Failed to create vault with plugin recipient: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	_ = v.Close()
+
+	v, err = vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault with plugin identity: %v", err)
+	}
+	defer v.Close()
+
+	secret, err := v.GetSecret("key")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+}
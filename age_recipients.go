@@ -0,0 +1,90 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// readRecipientsFile reads a recipients file in the format accepted by `age -R`: one X25519 or SSH public key
+// per line, with empty lines and lines starting with "#" ignored. A key list downloaded from a Git forge, such as
+// https://github.com/<user>.keys, is a valid recipients file. Like `age -R`, SSH keys of types that age cannot encrypt
+// to, such as ECDSA and security keys, are skipped; a warning is returned for each of them.
+func readRecipientsFile(path string) ([]string, []string, error) {
+	expandedPath, err := expandPath(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to expand recipients file path %s: %w", path, err)
+	}
+
+	data, err := os.ReadFile(expandedPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read recipients file %s: %w", expandedPath, err)
+	}
+
+	var recipients, warnings []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := parseRecipient(line, nil); err != nil {
+			if key, _, _, _, sshErr := ssh.ParseAuthorizedKey([]byte(line)); sshErr == nil {
+				warnings = append(warnings, fmt.Sprintf(
+					"recipients file %s: ignoring unsupported SSH key of type %q at line %d", expandedPath, key.Type(), n,
+				))
+				continue
+			}
+			return nil, nil, fmt.Errorf("%w: invalid recipient at line %d of %s: %w", ErrInvalidRecipient, n, expandedPath, err)
+		}
+		if !slices.Contains(recipients, line) {
+			recipients = append(recipients, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read recipients file %s: %w", expandedPath, err)
+	}
+	if len(recipients) == 0 {
+		return nil, nil, fmt.Errorf("%w: no recipients found in %s", ErrInvalidRecipient, expandedPath)
+	}
+
+	return recipients, warnings, nil
+}
+
+// syncRecipientsFile updates the vault recipients to match the configured recipients file, reporting whether they
+// changed. The vault must be re-encrypted when they do.
+func (v *AgeVault) syncRecipientsFile() (bool, error) {
+	if v.cfg.RecipientsFile == "" {
+		return false, nil
+	}
+
+	recipients, warnings, err := readRecipientsFile(v.cfg.RecipientsFile)
+	if err != nil {
+		return false, err
+	}
+	if slices.Equal(recipients, v.state.Recipients) {
+		return false, nil
+	}
+
+	// warn once per change of the file rather than every time it is read
+	if v.cfg.Warn != nil {
+		for _, warning := range warnings {
+			v.cfg.Warn(warning)
+		}
+	}
+
+	v.state.Recipients = recipients
+	return true, nil
+}
+
+func (v *AgeVault) errRecipientsFile() error {
+	return fmt.Errorf(
+		"%w: recipients are managed in %s - edit the file to change them",
+		ErrNotSupported, v.cfg.RecipientsFile,
+	)
+}
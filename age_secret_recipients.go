@@ -0,0 +1,325 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"filippo.io/age"
+)
+
+func (v *AgeVault) errPerSecretDisabled() error {
+	return fmt.Errorf("%w: secret recipients require per-secret encryption to be enabled", ErrNotSupported)
+}
+
+// encryptValue encrypts a secret value to the given recipients, or to every vault recipient if none are given
+func (v *AgeVault) encryptValue(value string, recipients []string) (string, error) {
+	targets := v.recipients
+	if len(recipients) > 0 {
+		targets = make([]age.Recipient, 0, len(recipients))
+		for _, r := range recipients {
+			recipient, err := parseRecipient(r, v.cfg.PluginUI)
+			if err != nil {
+				return "", fmt.Errorf("%w: invalid recipient %s: %w", ErrInvalidRecipient, r, err)
+			}
+			targets = append(targets, recipient)
+		}
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, targets...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create age encryptor: %w", err)
+	}
+	if _, err := io.WriteString(w, value); err != nil {
+		return "", fmt.Errorf("failed to encrypt secret value: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed to finalize encryption: %w", err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// decryptValue decrypts a secret value encrypted with encryptValue. ErrNoAccess is returned when none of the
+// vault identities is a recipient of the value.
+func (v *AgeVault) decryptValue(value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid encrypted secret value: %w", ErrDecryptionFailed, err)
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(data), v.identities...)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return "", fmt.Errorf("%w: not a recipient of this secret", ErrNoAccess)
+		}
+		return "", fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	plaintext, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrDecryptionFailed, err)
+	}
+	return string(plaintext), nil
+}
+
+// readValue returns the plaintext of a stored secret value
+func (v *AgeVault) readValue(value string) (string, error) {
+	if !v.state.PerSecret {
+		return value, nil
+	}
+	return v.decryptValue(value)
+}
+
+// writeValue returns the value to store for a secret that can be read by the given recipients
+func (v *AgeVault) writeValue(value string, recipients []string) (string, error) {
+	if !v.state.PerSecret {
+		return value, nil
+	}
+	return v.encryptValue(value, recipients)
+}
+
+// transformValues replaces the current and retained values of the secret with the result of fn
+func transformValues(entry *SecretEntry, fn func(string) (string, error)) error {
+	value, err := fn(entry.Value)
+	if err != nil {
+		return err
+	}
+	history := slices.Clone(entry.History)
+	for i := range history {
+		if history[i].Value, err = fn(history[i].Value); err != nil {
+			return err
+		}
+	}
+	entry.Value, entry.History = value, history
+	return nil
+}
+
+// reencryptEntry encrypts the current and retained values of the secret to its recipients
+func (v *AgeVault) reencryptEntry(entry *SecretEntry) error {
+	return transformValues(entry, func(value string) (string, error) {
+		plaintext, err := v.decryptValue(value)
+		if err != nil {
+			return "", err
+		}
+		return v.encryptValue(plaintext, entry.Recipients)
+	})
+}
+
+// setEntryRecipients restricts the secret to the recipients, re-encrypting its values when they change
+func (v *AgeVault) setEntryRecipients(entry *SecretEntry, recipients []string) error {
+	if slices.Equal(entry.Recipients, recipients) {
+		return nil
+	}
+	previous := entry.Recipients
+	entry.Recipients = recipients
+	if err := v.reencryptEntry(entry); err != nil {
+		entry.Recipients = previous
+		return err
+	}
+	return nil
+}
+
+// convertSecretEncryption encrypts or decrypts every secret value when per-secret encryption is switched on or
+// off in the configuration, reporting whether the state changed
+func (v *AgeVault) convertSecretEncryption() (bool, error) {
+	if v.state.PerSecret == v.cfg.PerSecretEncryption {
+		return false, nil
+	}
+
+	for key, entry := range v.state.Secrets {
+		var err error
+		if v.cfg.PerSecretEncryption {
+			err = transformValues(entry, func(value string) (string, error) {
+				return v.encryptValue(value, entry.Recipients)
+			})
+		} else {
+			err = transformValues(entry, v.decryptValue)
+			entry.Recipients = nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to convert secret %s: %w", key, err)
+		}
+	}
+
+	v.state.PerSecret = v.cfg.PerSecretEncryption
+	return true, nil
+}
+
+// snapshotRecipients records the vault recipients and secret entries, returning a function that restores them if
+// a recipient change fails part way through
+func (v *AgeVault) snapshotRecipients() func() {
+	recipients, parsed := slices.Clone(v.state.Recipients), slices.Clone(v.recipients)
+	entries := make(map[string]SecretEntry, len(v.state.Secrets))
+	for key, entry := range v.state.Secrets {
+		entries[key] = *entry
+	}
+	return func() {
+		v.state.Recipients, v.recipients = recipients, parsed
+		for key, entry := range entries {
+			*v.state.Secrets[key] = entry
+		}
+	}
+}
+
+// recipientsChanged parses the updated vault recipients and re-encrypts the secrets to them. A secret that cannot
+// be decrypted with the vault identities keeps the recipients that can still decrypt it, and is flagged for rotation
+// if any of them is no longer a vault recipient.
+func (v *AgeVault) recipientsChanged(previous []string) error {
+	// secret recipients must remain vault recipients
+	kept := make(map[string][]string, len(v.state.Secrets))
+	for key, entry := range v.state.Secrets {
+		if !v.state.PerSecret || len(entry.Recipients) == 0 {
+			continue
+		}
+		kept[key] = slices.DeleteFunc(slices.Clone(entry.Recipients), func(r string) bool {
+			return !slices.Contains(v.state.Recipients, r)
+		})
+		if len(kept[key]) == 0 {
+			return fmt.Errorf("secret %s would be left without recipients", key)
+		}
+	}
+
+	if err := v.parseRecipients(); err != nil {
+		return fmt.Errorf("failed to parse recipients: %w", err)
+	}
+	if !v.state.PerSecret {
+		return nil
+	}
+
+	for key, entry := range v.state.Secrets {
+		readers := previous
+		if len(entry.Recipients) > 0 {
+			if len(kept[key]) == len(entry.Recipients) {
+				continue
+			}
+			readers = entry.Recipients
+			entry.Recipients = kept[key]
+		}
+
+		err := v.reencryptEntry(entry)
+		switch {
+		case errors.Is(err, ErrNoAccess):
+			entry.Recipients = slices.Clone(readers)
+			if slices.ContainsFunc(readers, func(r string) bool { return !slices.Contains(v.state.Recipients, r) }) {
+				entry.Metadata.NeedsRotation = true
+			}
+		case err != nil:
+			return fmt.Errorf("failed to re-encrypt secret %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// readableBy returns the keys of the secrets that the recipient can decrypt, in sorted order
+func (v *AgeVault) readableBy(publicKey string) []string {
+	keys := make([]string, 0, len(v.state.Secrets))
+	for key, entry := range v.state.Secrets {
+		// without per-secret encryption, every recipient can decrypt the whole vault
+		if !v.state.PerSecret || len(entry.Recipients) == 0 || slices.Contains(entry.Recipients, publicKey) {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// validateSecretRecipients checks that every secret recipient is a vault recipient
+func (v *AgeVault) validateSecretRecipients(recipients []string) error {
+	for _, r := range recipients {
+		if !slices.Contains(v.state.Recipients, r) {
+			return fmt.Errorf("%w: %s is not a vault recipient", ErrInvalidRecipient, r)
+		}
+	}
+	return nil
+}
+
+func (v *AgeVault) AddSecretRecipient(key, publicKey string) error {
+	if !v.cfg.PerSecretEncryption {
+		return v.errPerSecretDisabled()
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+	if err := v.validateSecretRecipients([]string{publicKey}); err != nil {
+		return err
+	}
+	// secrets without their own recipients can already be read by every vault recipient
+	if len(entry.Recipients) == 0 || slices.Contains(entry.Recipients, publicKey) {
+		return nil
+	}
+
+	restore := v.snapshotRecipients()
+	if err := v.setEntryRecipients(entry, append(slices.Clone(entry.Recipients), publicKey)); err != nil {
+		return err
+	}
+	if err := v.save(); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+func (v *AgeVault) RemoveSecretRecipient(key, publicKey string) error {
+	if !v.cfg.PerSecretEncryption {
+		return v.errPerSecretDisabled()
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	current := entry.Recipients
+	if len(current) == 0 {
+		current = v.state.Recipients
+	}
+	i := slices.Index(current, publicKey)
+	if i < 0 {
+		return fmt.Errorf("recipient %s not found for secret %s", publicKey, key)
+	}
+	if len(current) <= 1 {
+		return fmt.Errorf("cannot remove the last recipient of secret %s", key)
+	}
+
+	restore := v.snapshotRecipients()
+	if err := v.setEntryRecipients(entry, slices.Delete(slices.Clone(current), i, i+1)); err != nil {
+		return err
+	}
+	// the removed recipient may have kept a copy of the value
+	entry.Metadata.NeedsRotation = true
+	if err := v.save(); err != nil {
+		restore()
+		return err
+	}
+	return nil
+}
+
+func (v *AgeVault) ListSecretRecipients(key string) ([]string, error) {
+	if !v.cfg.PerSecretEncryption {
+		return nil, v.errPerSecretDisabled()
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+	if len(entry.Recipients) == 0 {
+		return slices.Clone(v.state.Recipients), nil
+	}
+	return slices.Clone(entry.Recipients), nil
+}
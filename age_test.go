@@ -1,11 +1,25 @@
 package vault_test
 
 import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+
 	"github.com/flowexec/vault"
 )
 
@@ -62,6 +76,87 @@ func TestAgeIdentityResolver(t *testing.T) {
 	}
 }
 
+func TestAgeIdentityResolverIdentityFiles(t *testing.T) {
+	tempDir := t.TempDir()
+
+	first, _ := age.GenerateX25519Identity()
+	second, _ := age.GenerateX25519Identity()
+	keys := fmt.Sprintf("# created: 2024-01-01T00:00:00Z\n# public key: %s\n%s\n\n# backup key\n%s\n",
+		first.Recipient(), first, second)
+
+	keyFile := filepath.Join(tempDir, "keys.txt")
+	if err := os.WriteFile(keyFile, []byte(keys), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+	identities, err := vault.NewIdentityResolver([]vault.IdentitySource{{Type: "file", Path: keyFile}}).
+		ResolveIdentities()
+	if err != nil {
+		t.Fatalf("Failed to resolve identities from file with comments: %v", err)
+	}
+	if len(identities) != 2 {
+		t.Errorf("Expected 2 identities, got %d", len(identities))
+	}
+
+	// encrypt the identity file with a passphrase, as `age -p` would
+	recipient, _ := age.NewScryptRecipient("identity passphrase")
+	recipient.SetWorkFactor(10)
+	var buf bytes.Buffer
+	w, _ := age.Encrypt(&buf, recipient)
+	_, _ = w.Write([]byte(keys))
+	_ = w.Close()
+	encryptedFile := filepath.Join(tempDir, "keys.txt.age")
+	if err := os.WriteFile(encryptedFile, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("Failed to write encrypted identity file: %v", err)
+	}
+
+	prompts := 0
+	identities, err = vault.NewIdentityResolver([]vault.IdentitySource{{
+		Type: "file",
+		Path: encryptedFile,
+		Passphrase: &vault.PassphraseSource{Type: "callback", Callback: func() (string, error) {
+			prompts++
+			return "identity passphrase", nil
+		}},
+	}}).ResolveIdentities()
+	if err != nil {
+		t.Fatalf("Failed to resolve identities from encrypted file: %v", err)
+	}
+	if len(identities) != 2 || prompts != 1 {
+		t.Errorf("Expected 2 identities after 1 prompt, got %d identities after %d prompts", len(identities), prompts)
+	}
+
+	_, err = vault.NewIdentityResolver([]vault.IdentitySource{{Type: "file", Path: encryptedFile}}).
+		ResolveIdentities()
+	if !errors.Is(err, vault.ErrNoAccess) {
+		t.Errorf("Expected ErrNoAccess for encrypted file without a passphrase source, got: %v", err)
+	}
+}
+
+func TestAgeIdentityResolverCommand(t *testing.T) {
+	identity, _ := age.GenerateX25519Identity()
+	t.Setenv("TEST_AGE_COMMAND_IDENTITY", identity.String())
+
+	identities, err := vault.NewIdentityResolver([]vault.IdentitySource{{
+		Type:        "command",
+		Command:     `printf '# from a password manager\n%s\n' "$IDENTITY"`,
+		Environment: map[string]string{"IDENTITY": "$TEST_AGE_COMMAND_IDENTITY"},
+	}}).ResolveIdentities()
+	if err != nil {
+		t.Fatalf("Failed to resolve identity from command: %v", err)
+	}
+	if len(identities) != 1 {
+		t.Errorf("Expected 1 identity, got %d", len(identities))
+	}
+
+	_, err = vault.NewIdentityResolver([]vault.IdentitySource{{
+		Type:    "command",
+		Command: "echo 'vault is locked' >&2 && exit 1",
+	}}).ResolveIdentities()
+	if err == nil || !strings.Contains(err.Error(), "vault is locked") {
+		t.Errorf("Expected command error to include stderr, got: %v", err)
+	}
+}
+
 func TestAgeIdentityResolverErrors(t *testing.T) {
 	// Test invalid identity
 	t.Setenv("INVALID_AGE_IDENTITY", "not-a-valid-age-key")
@@ -358,6 +453,63 @@ func TestAgeVaultFileFormat(t *testing.T) {
 	}
 }
 
+func TestAgeVaultArmor(t *testing.T) {
+	tempDir := t.TempDir()
+
+	identity, _ := age.GenerateX25519Identity()
+	t.Setenv("ARMOR_TEST_IDENTITY", identity.String())
+	config := &vault.Config{
+		ID:   "armor-test",
+		Type: vault.ProviderTypeAge,
+		Age: &vault.AgeConfig{
+			StoragePath:     tempDir,
+			IdentitySources: []vault.IdentitySource{{Type: "env", Name: "ARMOR_TEST_IDENTITY"}},
+			Recipients:      []string{identity.Recipient().String()},
+		},
+	}
+	vaultFile := filepath.Join(tempDir, "vault-armor-test.age")
+
+	// a binary vault file is read and rewritten as armored once the option is enabled
+	v, err := vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	_ = v.Close()
+
+	config.Age.Armor = true
+	v, err = vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Failed to open binary vault with armor enabled: %v", err)
+	}
+	if err := v.SetSecret("other", vault.NewSecretValue([]byte("other-value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	_ = v.Close()
+
+	data, err := os.ReadFile(vaultFile)
+	if err != nil {
+		t.Fatalf("Failed to read vault file: %v", err)
+	}
+	if !strings.HasPrefix(string(data), "-----BEGIN AGE ENCRYPTED FILE-----\n") ||
+		!strings.HasSuffix(string(data), "-----END AGE ENCRYPTED FILE-----\n") {
+		t.Errorf("Expected an armored vault file, got:\n%s", data)
+	}
+
+	// an armored vault file is read without the option
+	config.Age.Armor = false
+	v, err = vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Failed to open armored vault: %v", err)
+	}
+	defer v.Close()
+	if secret, err := v.GetSecret("key"); err != nil || secret.PlainTextString() != "value" {
+		t.Errorf("Expected to read secret from armored vault, got %v, %v", secret, err)
+	}
+}
+
 func TestAgeVaultNoRecipients(t *testing.T) {
 	tempDir := t.TempDir()
 
@@ -433,3 +585,602 @@ func TestAgeVaultPathExpansion(t *testing.T) {
 		t.Fatalf("Failed to set secret with relative path identity: %v", err)
 	}
 }
+
+func TestAgeVaultSSHKeys(t *testing.T) {
+	tempDir := t.TempDir()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	keyFile := filepath.Join(tempDir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to create public key: %v", err)
+	}
+	recipient := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate rsa key: %v", err)
+	}
+	rsaPub, err := ssh.NewPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to create rsa public key: %v", err)
+	}
+
+	config := &vault.Config{
+		ID:   "ssh-test",
+		Type: vault.ProviderTypeAge,
+		Age: &vault.AgeConfig{
+			StoragePath:     tempDir,
+			IdentitySources: []vault.IdentitySource{{Type: "file", Path: keyFile}},
+			Recipients:      []string{recipient + " user@host"},
+		},
+	}
+
+	v, err := vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Failed to create vault with ssh recipient: %v", err)
+	}
+	if err := v.AddRecipient(string(ssh.MarshalAuthorizedKey(rsaPub))); err != nil {
+		t.Fatalf("Failed to add ssh-rsa recipient: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	_ = v.Close()
+
+	v, err = vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault with ssh identity: %v", err)
+	}
+	defer v.Close()
+
+	secret, err := v.GetSecret("key")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+	recipients, _ := v.ListRecipients()
+	if len(recipients) != 2 {
+		t.Errorf("Expected 2 recipients, got %v", recipients)
+	}
+}
+
+func TestAgeVaultPassphrase(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("AGE_TEST_PASSPHRASE", "correct horse battery staple")
+
+	opts := []vault.Option{
+		vault.WithProvider(vault.ProviderTypeAge),
+		vault.WithAgePath(tempDir),
+		vault.WithAgePassphrase(vault.PassphraseSource{Type: "env", Name: "AGE_TEST_PASSPHRASE"}),
+	}
+
+	v, _, err := vault.New("passphrase-test", opts...)
+	if err != nil {
+		t.Fatalf("Failed to create passphrase vault: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	rm, ok := vault.HasRecipientManagement(v)
+	if !ok {
+		t.Fatal("Expected age vault to implement recipient management")
+	}
+	recipient := "age1wnhg53pg2qfsfxwvxvlg6pygw5uzwcyhj2dqhg0k83fvjexf9pzsxqdvs0"
+	if err := rm.AddRecipient(recipient); !errors.Is(err, vault.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported when adding a recipient, got: %v", err)
+	}
+	if _, err := rm.ListRecipients(); !errors.Is(err, vault.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported when listing recipients, got: %v", err)
+	}
+	_ = v.Close()
+
+	prompted := false
+	v, _, err = vault.New("passphrase-test",
+		vault.WithProvider(vault.ProviderTypeAge),
+		vault.WithAgePath(tempDir),
+		vault.WithAgePassphrase(vault.PassphraseSource{Type: "callback", Callback: func() (string, error) {
+			prompted = true
+			return "correct horse battery staple", nil
+		}}),
+	)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault with passphrase callback: %v", err)
+	}
+	defer v.Close()
+	if !prompted {
+		t.Error("Expected passphrase callback to be called")
+	}
+
+	secret, err := v.GetSecret("key")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+
+	t.Setenv("AGE_TEST_PASSPHRASE", "wrong passphrase")
+	if _, _, err := vault.New("passphrase-test", opts...); err == nil {
+		t.Error("Expected wrong passphrase to fail to open the vault")
+	}
+}
+
+func TestAgeVaultRecipientsFile(t *testing.T) {
+	tempDir := t.TempDir()
+
+	owner, _ := age.GenerateX25519Identity()
+	former, _ := age.GenerateX25519Identity()
+	joiner, _ := age.GenerateX25519Identity()
+
+	recipientsFile := filepath.Join(tempDir, "recipients.txt")
+	writeRecipients := func(recipients ...*age.X25519Identity) {
+		content := "# vault members\n"
+		for _, r := range recipients {
+			content += r.Recipient().String() + "\n\n"
+		}
+		if err := os.WriteFile(recipientsFile, []byte(content), 0600); err != nil {
+			t.Fatalf("Failed to write recipients file: %v", err)
+		}
+	}
+	openAs := func(identity *age.X25519Identity) (*vault.AgeVault, error) {
+		t.Setenv("RECIPIENTS_FILE_IDENTITY", identity.String())
+		return vault.NewAgeVault(&vault.Config{
+			ID:   "recipients-file-test",
+			Type: vault.ProviderTypeAge,
+			Age: &vault.AgeConfig{
+				StoragePath:     tempDir,
+				IdentitySources: []vault.IdentitySource{{Type: "env", Name: "RECIPIENTS_FILE_IDENTITY"}},
+				RecipientsFile:  recipientsFile,
+			},
+		})
+	}
+
+	writeRecipients(owner, former)
+	v, err := openAs(owner)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	if err := v.AddRecipient(joiner.Recipient().String()); !errors.Is(err, vault.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported when adding a recipient, got: %v", err)
+	}
+	_ = v.Close()
+
+	v, err = openAs(former)
+	if err != nil {
+		t.Fatalf("Expected listed recipient to open the vault: %v", err)
+	}
+	_ = v.Close()
+
+	// membership changes are picked up, and the vault re-encrypted, the next time the vault is opened
+	writeRecipients(owner, joiner)
+	v, err = openAs(owner)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault: %v", err)
+	}
+	recipients, _ := v.ListRecipients()
+	if len(recipients) != 2 || recipients[1] != joiner.Recipient().String() {
+		t.Errorf("Expected recipients to match the recipients file, got %v", recipients)
+	}
+	_ = v.Close()
+
+	if _, err := openAs(former); err == nil {
+		t.Error("Expected removed recipient to be unable to open the vault")
+	}
+	v, err = openAs(joiner)
+	if err != nil {
+		t.Fatalf("Expected added recipient to open the vault: %v", err)
+	}
+	defer v.Close()
+	if secret, err := v.GetSecret("key"); err != nil || secret.PlainTextString() != "value" {
+		t.Errorf("Expected added recipient to read the secret, got %v, %v", secret, err)
+	}
+}
+
+func TestAgeVaultRecipientsFileSSHKeys(t *testing.T) {
+	tempDir := t.TempDir()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ed25519 key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	keyFile := filepath.Join(tempDir, "id_ed25519")
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("Failed to write identity file: %v", err)
+	}
+	edPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("Failed to create public key: %v", err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate ecdsa key: %v", err)
+	}
+	ecdsaPub, err := ssh.NewPublicKey(&ecdsaKey.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to create ecdsa public key: %v", err)
+	}
+	skPub := ssh.Marshal(struct {
+		Name        string
+		KeyBytes    []byte
+		Application string
+	}{ssh.KeyAlgoSKED25519, pub, "ssh:"})
+
+	// a key list in the format served at https://github.com/<user>.keys
+	recipient := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(edPub)))
+	keys := strings.Join([]string{
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ecdsaPub))),
+		recipient,
+		ssh.KeyAlgoSKED25519 + " " + base64.StdEncoding.EncodeToString(skPub),
+	}, "\n") + "\n"
+	recipientsFile := filepath.Join(tempDir, "user.keys")
+	if err := os.WriteFile(recipientsFile, []byte(keys), 0600); err != nil {
+		t.Fatalf("Failed to write recipients file: %v", err)
+	}
+
+	config := &vault.Config{
+		ID:   "ssh-keys-file-test",
+		Type: vault.ProviderTypeAge,
+		Age: &vault.AgeConfig{
+			StoragePath:     tempDir,
+			IdentitySources: []vault.IdentitySource{{Type: "file", Path: keyFile}},
+			RecipientsFile:  recipientsFile,
+		},
+	}
+	var warnings []string
+	config.Age.Warn = func(message string) { warnings = append(warnings, message) }
+	v, err := vault.NewAgeVault(config)
+	if err != nil {
+		t.Fatalf("Expected unsupported SSH keys to be skipped, got: %v", err)
+	}
+	defer v.Close()
+	if recipients, _ := v.ListRecipients(); !slices.Equal(recipients, []string{recipient}) {
+		t.Errorf("Expected only the ed25519 key to be a recipient, got %v", recipients)
+	}
+	if len(warnings) != 2 {
+		t.Errorf("Expected a warning for each skipped key, got %v", warnings)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	if err := os.WriteFile(recipientsFile, []byte(keys+"ssh-ed25519 not-a-key\n"), 0600); err != nil {
+		t.Fatalf("Failed to write recipients file: %v", err)
+	}
+	if _, err := vault.NewAgeVault(config); !errors.Is(err, vault.ErrInvalidRecipient) {
+		t.Errorf("Expected ErrInvalidRecipient for a malformed key, got: %v", err)
+	}
+}
+
+func TestAgeVaultPerSecretEncryption(t *testing.T) {
+	tempDir := t.TempDir()
+
+	prod, _ := age.GenerateX25519Identity()
+	staging, _ := age.GenerateX25519Identity()
+
+	openAs := func(identity *age.X25519Identity) (*vault.AgeVault, error) {
+		t.Setenv("PER_SECRET_IDENTITY", identity.String())
+		return vault.NewAgeVault(&vault.Config{
+			ID:   "per-secret-test",
+			Type: vault.ProviderTypeAge,
+			Age: &vault.AgeConfig{
+				StoragePath:         tempDir,
+				IdentitySources:     []vault.IdentitySource{{Type: "env", Name: "PER_SECRET_IDENTITY"}},
+				Recipients:          []string{prod.Recipient().String(), staging.Recipient().String()},
+				PerSecretEncryption: true,
+			},
+		})
+	}
+
+	v, err := openAs(prod)
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	if err := v.SetSecret("shared", vault.NewSecretValue([]byte("shared-value"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	err = v.SetSecretWithOptions("prod-db", vault.NewSecretValue([]byte("prod-password")), vault.SetOptions{
+		Recipients: []string{prod.Recipient().String()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to set scoped secret: %v", err)
+	}
+	err = v.SetSecretWithOptions("other", vault.NewSecretValue([]byte("value")), vault.SetOptions{
+		Recipients: []string{"age1qyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqszqgpqyqs3290gq"},
+	})
+	if !errors.Is(err, vault.ErrInvalidRecipient) {
+		t.Errorf("Expected ErrInvalidRecipient for a recipient outside the vault, got: %v", err)
+	}
+	_ = v.Close()
+
+	v, err = openAs(staging)
+	if err != nil {
+		t.Fatalf("Expected staging to open the vault: %v", err)
+	}
+	if secret, err := v.GetSecret("shared"); err != nil || secret.PlainTextString() != "shared-value" {
+		t.Errorf("Expected staging to read the shared secret, got %v, %v", secret, err)
+	}
+	if _, err := v.GetSecret("prod-db"); !errors.Is(err, vault.ErrNoAccess) {
+		t.Errorf("Expected ErrNoAccess reading a secret scoped to prod, got: %v", err)
+	}
+	// overwriting a secret the caller can read does not require access to the other secrets
+	if err := v.SetSecret("shared", vault.NewSecretValue([]byte("shared-value-2"))); err != nil {
+		t.Fatalf("Failed to update secret: %v", err)
+	}
+	_ = v.Close()
+
+	v, err = openAs(prod)
+	if err != nil {
+		t.Fatalf("Failed to reopen vault: %v", err)
+	}
+	defer v.Close()
+	recipients, err := v.ListSecretRecipients("prod-db")
+	if err != nil || len(recipients) != 1 || recipients[0] != prod.Recipient().String() {
+		t.Errorf("Expected prod-db to be scoped to prod, got %v, %v", recipients, err)
+	}
+	if err := v.AddSecretRecipient("prod-db", staging.Recipient().String()); err != nil {
+		t.Fatalf("Failed to add secret recipient: %v", err)
+	}
+	if err := v.RemoveSecretRecipient("prod-db", prod.Recipient().String()); err != nil {
+		t.Fatalf("Failed to remove secret recipient: %v", err)
+	}
+	if _, err := v.GetSecret("prod-db"); !errors.Is(err, vault.ErrNoAccess) {
+		t.Errorf("Expected ErrNoAccess after removing prod from the secret, got: %v", err)
+	}
+	if err := v.RemoveRecipient(staging.Recipient().String()); err == nil {
+		t.Error("Expected an error removing the only recipient of a secret")
+	}
+	if recipients, _ := v.ListRecipients(); len(recipients) != 2 {
+		t.Errorf("Expected vault recipients to be unchanged, got %v", recipients)
+	}
+	if secret, err := v.GetSecret("shared"); err != nil || secret.PlainTextString() != "shared-value-2" {
+		t.Errorf("Expected prod to read the shared secret, got %v, %v", secret, err)
+	}
+}
+
+func TestAgeVaultRecipientChangeWithoutAccess(t *testing.T) {
+	tempDir := t.TempDir()
+
+	owner, _ := age.GenerateX25519Identity()
+	prod, _ := age.GenerateX25519Identity()
+	staging, _ := age.GenerateX25519Identity()
+	ci, _ := age.GenerateX25519Identity()
+
+	openAs := func(identity *age.X25519Identity) *vault.AgeVault {
+		t.Setenv("NO_ACCESS_TEST_IDENTITY", identity.String())
+		v, err := vault.NewAgeVault(&vault.Config{
+			ID:   "no-access-test",
+			Type: vault.ProviderTypeAge,
+			Age: &vault.AgeConfig{
+				StoragePath:     tempDir,
+				IdentitySources: []vault.IdentitySource{{Type: "env", Name: "NO_ACCESS_TEST_IDENTITY"}},
+				Recipients: []string{
+					owner.Recipient().String(), prod.Recipient().String(), staging.Recipient().String(),
+				},
+				PerSecretEncryption: true,
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to open vault: %v", err)
+		}
+		return v
+	}
+
+	v := openAs(owner)
+	readers := []string{prod.Recipient().String(), staging.Recipient().String()}
+	opts := vault.SetOptions{Recipients: readers}
+	if err := v.SetSecretWithOptions("prod-db", vault.NewSecretValue([]byte("value")), opts); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	// the owner cannot re-encrypt prod-db, so staging can still decrypt it
+	report, err := v.RemoveRecipientWithReport(staging.Recipient().String())
+	if err != nil {
+		t.Fatalf("Failed to remove recipient: %v", err)
+	}
+	if !slices.Contains(report.Secrets, "prod-db") {
+		t.Errorf("Expected prod-db to be reported, got %v", report.Secrets)
+	}
+	recipients, err := v.ListSecretRecipients("prod-db")
+	if err != nil || !slices.Equal(recipients, readers) {
+		t.Errorf("Expected prod-db to still list %v, got %v, %v", readers, recipients, err)
+	}
+	if metadata, _ := v.GetSecretMetadata("prod-db"); !metadata.NeedsRotation {
+		t.Error("Expected prod-db to need rotation")
+	}
+	_ = v.Close()
+
+	// a recipient change made by an identity with access re-encrypts the secret without the removed recipient
+	v = openAs(prod)
+	defer v.Close()
+	if err := v.AddRecipient(ci.Recipient().String()); err != nil {
+		t.Fatalf("Failed to add recipient: %v", err)
+	}
+	recipients, err = v.ListSecretRecipients("prod-db")
+	if err != nil || !slices.Equal(recipients, []string{prod.Recipient().String()}) {
+		t.Errorf("Expected prod-db to be re-encrypted for prod only, got %v, %v", recipients, err)
+	}
+	if secret, err := v.GetSecret("prod-db"); err != nil || secret.PlainTextString() != "value" {
+		t.Errorf("Expected prod to read prod-db, got %v, %v", secret, err)
+	}
+}
+
+func TestAgeVaultRemoveRecipientReport(t *testing.T) {
+	tempDir := t.TempDir()
+
+	owner, _ := age.GenerateX25519Identity()
+	prod, _ := age.GenerateX25519Identity()
+	staging, _ := age.GenerateX25519Identity()
+	t.Setenv("ROTATION_TEST_IDENTITY", owner.String())
+
+	v, err := vault.NewAgeVault(&vault.Config{
+		ID:   "rotation-test",
+		Type: vault.ProviderTypeAge,
+		Age: &vault.AgeConfig{
+			StoragePath:     tempDir,
+			IdentitySources: []vault.IdentitySource{{Type: "env", Name: "ROTATION_TEST_IDENTITY"}},
+			Recipients: []string{
+				owner.Recipient().String(), prod.Recipient().String(), staging.Recipient().String(),
+			},
+			PerSecretEncryption: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	defer v.Close()
+
+	secrets := map[string][]string{
+		"shared":     nil,
+		"prod-db":    {owner.Recipient().String(), prod.Recipient().String()},
+		"staging-db": {owner.Recipient().String(), staging.Recipient().String()},
+	}
+	for key, recipients := range secrets {
+		opts := vault.SetOptions{Recipients: recipients}
+		if err := v.SetSecretWithOptions(key, vault.NewSecretValue([]byte("value")), opts); err != nil {
+			t.Fatalf("Failed to set secret %s: %v", key, err)
+		}
+	}
+
+	report, err := v.RemoveRecipientWithReport(prod.Recipient().String())
+	if err != nil {
+		t.Fatalf("Failed to remove recipient: %v", err)
+	}
+	if len(report.Secrets) != 2 || report.Secrets[0] != "prod-db" || report.Secrets[1] != "shared" {
+		t.Errorf("Expected prod-db and shared to be reported, got %v", report.Secrets)
+	}
+
+	pending, err := v.ListPendingRotations()
+	if err != nil {
+		t.Fatalf("Failed to list pending rotations: %v", err)
+	}
+	if len(pending) != 2 || pending[0] != "prod-db" || pending[1] != "shared" {
+		t.Errorf("Expected prod-db and shared to need rotation, got %v", pending)
+	}
+	if metadata, _ := v.GetSecretMetadata("staging-db"); metadata.NeedsRotation {
+		t.Error("Expected staging-db not to need rotation")
+	}
+
+	if err := v.SetSecret("shared", vault.NewSecretValue([]byte("rotated"))); err != nil {
+		t.Fatalf("Failed to rotate secret: %v", err)
+	}
+	if pending, _ := v.ListPendingRotations(); len(pending) != 1 || pending[0] != "prod-db" {
+		t.Errorf("Expected only prod-db to need rotation after rotating shared, got %v", pending)
+	}
+	if recipients, _ := v.ListSecretRecipients("prod-db"); len(recipients) != 1 {
+		t.Errorf("Expected removed recipient to be dropped from prod-db, got %v", recipients)
+	}
+}
+
+func TestAgeVaultRecipientChangeRollback(t *testing.T) {
+	tempDir := t.TempDir()
+
+	owner, _ := age.GenerateX25519Identity()
+	other, _ := age.GenerateX25519Identity()
+	t.Setenv("ROLLBACK_TEST_IDENTITY", owner.String())
+
+	v, err := vault.NewAgeVault(&vault.Config{
+		ID:   "rollback-test",
+		Type: vault.ProviderTypeAge,
+		Age: &vault.AgeConfig{
+			StoragePath:         tempDir,
+			IdentitySources:     []vault.IdentitySource{{Type: "env", Name: "ROLLBACK_TEST_IDENTITY"}},
+			Recipients:          []string{owner.Recipient().String(), other.Recipient().String()},
+			PerSecretEncryption: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create vault: %v", err)
+	}
+	defer v.Close()
+
+	opts := vault.SetOptions{Recipients: []string{owner.Recipient().String(), other.Recipient().String()}}
+	if err := v.SetSecretWithOptions("shared", vault.NewSecretValue([]byte("value")), opts); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	opts = vault.SetOptions{Recipients: []string{owner.Recipient().String()}}
+	if err := v.SetSecretWithOptions("private", vault.NewSecretValue([]byte("value")), opts); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	// a directory in place of the temp file makes the save fail after the secrets were re-encrypted
+	tempFile := filepath.Join(tempDir, "vault-rollback-test.age.tmp")
+	if err := os.Mkdir(tempFile, 0750); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	if _, err := v.RemoveRecipientWithReport(other.Recipient().String()); err == nil {
+		t.Fatal("Expected removing a recipient to fail when the vault cannot be saved")
+	}
+
+	if recipients, _ := v.ListRecipients(); len(recipients) != 2 {
+		t.Errorf("Expected vault recipients to be restored, got %v", recipients)
+	}
+	if recipients, _ := v.ListSecretRecipients("shared"); len(recipients) != 2 {
+		t.Errorf("Expected secret recipients to be restored, got %v", recipients)
+	}
+	if pending, _ := v.ListPendingRotations(); len(pending) != 0 {
+		t.Errorf("Expected no secrets to need rotation, got %v", pending)
+	}
+
+	if err := v.RemoveSecretRecipient("shared", other.Recipient().String()); err == nil {
+		t.Fatal("Expected removing a secret recipient to fail when the vault cannot be saved")
+	}
+	if recipients, _ := v.ListSecretRecipients("shared"); len(recipients) != 2 {
+		t.Errorf("Expected secret recipients to be restored, got %v", recipients)
+	}
+	if pending, _ := v.ListPendingRotations(); len(pending) != 0 {
+		t.Errorf("Expected no secrets to need rotation, got %v", pending)
+	}
+	if err := v.AddSecretRecipient("private", other.Recipient().String()); err == nil {
+		t.Fatal("Expected adding a secret recipient to fail when the vault cannot be saved")
+	}
+	if recipients, _ := v.ListSecretRecipients("private"); len(recipients) != 1 {
+		t.Errorf("Expected secret recipients to be restored, got %v", recipients)
+	}
+
+	if err := os.Remove(tempFile); err != nil {
+		t.Fatalf("Failed to remove directory: %v", err)
+	}
+	if err := v.SetSecret("other", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to save vault: %v", err)
+	}
+
+	// the secret must still be encrypted to the restored recipients
+	t.Setenv("ROLLBACK_OTHER_IDENTITY", other.String())
+	reopened, err := vault.NewAgeVault(&vault.Config{
+		ID:   "rollback-test",
+		Type: vault.ProviderTypeAge,
+		Age: &vault.AgeConfig{
+			StoragePath:         tempDir,
+			IdentitySources:     []vault.IdentitySource{{Type: "env", Name: "ROLLBACK_OTHER_IDENTITY"}},
+			PerSecretEncryption: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to open vault as the other recipient: %v", err)
+	}
+	defer reopened.Close()
+	if secret, err := reopened.GetSecret("shared"); err != nil || secret.PlainTextString() != "value" {
+		t.Errorf("Expected other recipient to read the secret, got %v, %v", secret, err)
+	}
+}
@@ -0,0 +1,69 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultCommandSourceTimeout bounds how long a key or identity command may run when no timeout is configured
+const defaultCommandSourceTimeout = 30 * time.Second
+
+// commandSourceEnv lists the environment variables passed through to key and identity commands. The rest of the
+// environment is withheld so that unrelated secrets are not exposed to the command; anything else it needs must be
+// set explicitly in the source environment.
+var commandSourceEnv = []string{"PATH", "HOME", "USER", "LOGNAME", "LANG", "LC_ALL", "TMPDIR", "TERM"}
+
+// runSourceCommand runs the command of a key or identity source and returns its standard output. The command is run
+// with a scrubbed environment and is stopped when the timeout elapses.
+func runSourceCommand(command, timeout string, env map[string]string) (string, error) {
+	dur := defaultCommandSourceTimeout
+	if timeout != "" {
+		var err error
+		if dur, err = time.ParseDuration(timeout); err != nil {
+			return "", fmt.Errorf("invalid command timeout %s: %w", timeout, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dur)
+	defer cancel()
+
+	envList := make([]string, 0, len(commandSourceEnv)+len(env))
+	for _, name := range commandSourceEnv {
+		if value, ok := os.LookupEnv(name); ok {
+			envList = append(envList, name+"="+value)
+		}
+	}
+	// expand a copy so that the source keeps its unexpanded values for the next run
+	for name, value := range expandEnv(maps.Clone(env)) {
+		envList = append(envList, name+"="+value)
+	}
+
+	stdout := &strings.Builder{}
+	stderr := &strings.Builder{}
+	if err := runShell(ctx, command, strings.NewReader(""), stdout, stderr, "", envList); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("command timed out after %s", dur)
+		}
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// validateCommandSource checks the command and timeout of a key or identity source
+func validateCommandSource(kind, command, timeout string) error {
+	if command == "" {
+		return fmt.Errorf("%w: command is required for command %s source", ErrInvalidConfig, kind)
+	}
+	if timeout != "" {
+		if dur, err := time.ParseDuration(timeout); err != nil || dur <= 0 {
+			return fmt.Errorf("%w: invalid timeout for command %s source: %s", ErrInvalidConfig, kind, timeout)
+		}
+	}
+	return nil
+}
@@ -5,6 +5,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+
+	"filippo.io/age/plugin"
+
+	"github.com/flowexec/vault/crypto"
 )
 
 type ProviderType string
@@ -99,12 +103,54 @@ func LoadConfigJSON(path string) (Config, error) {
 // IdentitySource represents a source for the local vault identity keys
 type IdentitySource struct {
 	// Type of identity source
-	// Must be one of: "env", "file"
+	// Must be one of: "env", "file", "command"
 	Type string `json:"type"`
 	// Path to the identity file (for "file" type)
 	Path string `json:"fullPath,omitempty"`
 	// Environment variable name (for "env" type)
 	Name string `json:"name,omitempty"`
+	// Passphrase unlocks an identity file that is itself encrypted with a passphrase (for "file" type)
+	Passphrase *PassphraseSource `json:"passphrase,omitempty"`
+	// Command that prints the identity to stdout, e.g. `op read op://vault/age/identity` (for "command" type)
+	Command string `json:"command,omitempty"`
+	// Timeout duration string for the command (for "command" type). Defaults to 30s.
+	Timeout string `json:"timeout,omitempty"`
+	// Environment variables set for the command (for "command" type). Only a minimal set of variables, such
+	// as PATH and HOME, is inherited from the current environment.
+	Environment map[string]string `json:"environment,omitempty"`
+}
+
+// PassphraseSource represents a source for a vault passphrase
+type PassphraseSource struct {
+	// Type of passphrase source
+	// Must be one of: "env", "file", "callback"
+	Type string `json:"type"`
+	// Environment variable name (for "env" type)
+	Name string `json:"name,omitempty"`
+	// Path to the passphrase file (for "file" type)
+	Path string `json:"path,omitempty"`
+	// Callback returns the passphrase, e.g. by prompting the user (for "callback" type)
+	Callback func() (string, error) `json:"-"`
+}
+
+func (s *PassphraseSource) Validate() error {
+	switch s.Type {
+	case envSource:
+		if s.Name == "" {
+			return fmt.Errorf("%w: name is required for env passphrase source", ErrInvalidConfig)
+		}
+	case fileSource:
+		if s.Path == "" {
+			return fmt.Errorf("%w: path is required for file passphrase source", ErrInvalidConfig)
+		}
+	case callbackSource:
+		if s.Callback == nil {
+			return fmt.Errorf("%w: callback is required for callback passphrase source", ErrInvalidConfig)
+		}
+	default:
+		return fmt.Errorf("%w: invalid passphrase source type: %s", ErrInvalidConfig, s.Type)
+	}
+	return nil
 }
 
 // AgeConfig contains local (age-based) vault configuration
@@ -115,27 +161,83 @@ type AgeConfig struct {
 	// Identity sources for decryption (in order of preference)
 	IdentitySources []IdentitySource `json:"identity_sources,omitempty"`
 
-	// Recipients who can decrypt secrets
+	// Recipients who can decrypt secrets, as age X25519 or SSH public keys
 	Recipients []string `json:"recipients,omitempty"`
+
+	// RecipientsFile lists the recipients who can decrypt secrets, one per line in the format accepted by
+	// `age -R`. It is re-read whenever the vault is opened or saved, and the vault is re-encrypted when it changes.
+	RecipientsFile string `json:"recipients_file,omitempty"`
+
+	// HistorySize is the number of prior versions kept for each secret
+	HistorySize int `json:"history_size,omitempty"`
+
+	// Passphrase encrypts the vault with a passphrase instead of recipients. Identity sources and recipients
+	// are not used when it is set.
+	Passphrase *PassphraseSource `json:"passphrase,omitempty"`
+
+	// Armor writes the vault file in the ASCII armored (PEM) format instead of binary, which is easier to diff and
+	// review. Vault files in either format can be read regardless of this setting.
+	Armor bool `json:"armor,omitempty"`
+
+	// PerSecretEncryption encrypts each secret value on its own, so that individual secrets can be restricted to a
+	// subset of the vault recipients. Secrets are readable by every vault recipient unless restricted.
+	PerSecretEncryption bool `json:"per_secret_encryption,omitempty"`
+
+	// PluginUI handles requests from age plugins to interact with the user, e.g. to enter a hardware token PIN
+	PluginUI *plugin.ClientUI `json:"-"`
+
+	// Warn receives warnings that do not stop the vault from being used, such as keys skipped in the recipients
+	// file. Warnings are discarded when it is not set.
+	Warn func(message string) `json:"-"`
 }
 
 func (c *AgeConfig) Validate() error {
 	if c.StoragePath == "" {
 		return fmt.Errorf("%w: storage path is required for age vault", ErrInvalidConfig)
 	}
+	if err := validateHistorySize(c.HistorySize); err != nil {
+		return err
+	}
+	if c.Passphrase != nil {
+		if len(c.IdentitySources) > 0 || len(c.Recipients) > 0 || c.RecipientsFile != "" {
+			return fmt.Errorf(
+				"%w: identity sources and recipients cannot be used with a passphrase-protected age vault",
+				ErrInvalidConfig,
+			)
+		}
+		if c.PerSecretEncryption {
+			return fmt.Errorf(
+				"%w: per-secret encryption cannot be used with a passphrase-protected age vault", ErrInvalidConfig,
+			)
+		}
+		return c.Passphrase.Validate()
+	}
 	if len(c.IdentitySources) == 0 {
 		return fmt.Errorf("%w: at least one identity source is required for age vault", ErrInvalidConfig)
 	}
+	if len(c.Recipients) > 0 && c.RecipientsFile != "" {
+		return fmt.Errorf("%w: recipients and recipients file cannot both be set", ErrInvalidConfig)
+	}
 	for _, source := range c.IdentitySources {
-		if source.Type != envSource && source.Type != fileSource {
+		if source.Type != envSource && source.Type != fileSource && source.Type != commandSource {
 			return fmt.Errorf("%w: invalid identity source type: %s", ErrInvalidConfig, source.Type)
 		}
+		if source.Type == commandSource {
+			if err := validateCommandSource("identity", source.Command, source.Timeout); err != nil {
+				return err
+			}
+		}
 		if source.Type == fileSource && source.Path == "" {
 			return fmt.Errorf("%w: path is required for file identity source", ErrInvalidConfig)
 		}
 		if source.Type == envSource && source.Name == "" {
 			return fmt.Errorf("%w: name is required for env identity source", ErrInvalidConfig)
 		}
+		if source.Passphrase != nil {
+			if err := source.Passphrase.Validate(); err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
@@ -143,12 +245,19 @@ func (c *AgeConfig) Validate() error {
 // KeySource represents a source for the local vault encryption keys
 type KeySource struct {
 	// Type of data encryption key source
-	// Must be one of: "env", "file"
+	// Must be one of: "env", "file", "passphrase", "command"
 	Type string `json:"type"`
-	// Path to the identity file (for "file" type)
+	// Path to the identity file (for "file" type) or passphrase file (for "passphrase" type)
 	Path string `json:"fullPath,omitempty"`
-	// Environment variable name (for "env" type)
+	// Environment variable name (for "env" type, or "passphrase" type when no path is set)
 	Name string `json:"name,omitempty"`
+	// Command that prints the key to stdout, e.g. `pass show vault/key` (for "command" type)
+	Command string `json:"command,omitempty"`
+	// Timeout duration string for the command (for "command" type). Defaults to 30s.
+	Timeout string `json:"timeout,omitempty"`
+	// Environment variables set for the command (for "command" type). Only a minimal set of variables, such
+	// as PATH and HOME, is inherited from the current environment.
+	Environment map[string]string `json:"environment,omitempty"`
 }
 
 // AesConfig contains local (AES256-based) vault configuration
@@ -157,6 +266,11 @@ type AesConfig struct {
 	StoragePath string `json:"storage_path"`
 	// DEK sources for decryption (in order of preference)
 	KeySource []KeySource `json:"key_sources,omitempty"`
+	// HistorySize is the number of prior versions kept for each secret
+	HistorySize int `json:"history_size,omitempty"`
+	// KDF selects the algorithm and cost parameters used to derive keys from passphrase key sources.
+	// Defaults to scrypt.
+	KDF *crypto.KDFParams `json:"kdf,omitempty"`
 }
 
 func (c *AesConfig) Validate() error {
@@ -166,8 +280,17 @@ func (c *AesConfig) Validate() error {
 	if len(c.KeySource) == 0 {
 		return fmt.Errorf("%w: at least one key source is required for AES vault", ErrInvalidConfig)
 	}
+	if err := validateHistorySize(c.HistorySize); err != nil {
+		return err
+	}
 	for _, source := range c.KeySource {
-		if source.Type != envSource && source.Type != fileSource {
+		switch source.Type {
+		case envSource, fileSource, passphraseSource:
+		case commandSource:
+			if err := validateCommandSource("key", source.Command, source.Timeout); err != nil {
+				return err
+			}
+		default:
 			return fmt.Errorf("%w: invalid key source type: %s", ErrInvalidConfig, source.Type)
 		}
 		if source.Type == fileSource && source.Path == "" {
@@ -176,6 +299,14 @@ func (c *AesConfig) Validate() error {
 		if source.Type == envSource && source.Name == "" {
 			return fmt.Errorf("%w: name is required for env key source", ErrInvalidConfig)
 		}
+		if source.Type == passphraseSource && source.Name == "" && source.Path == "" {
+			return fmt.Errorf("%w: name or path is required for passphrase key source", ErrInvalidConfig)
+		}
+	}
+	if c.KDF != nil {
+		if _, err := crypto.NewKDF(*c.KDF); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidConfig, err)
+		}
 	}
 	return nil
 }
@@ -188,6 +319,14 @@ type CommandConfig struct {
 	OutputTemplate string `json:"output,omitempty"`
 	// InputTemplate for providing input to the command
 	InputTemplate string `json:"input,omitempty"`
+	// ValueVia is how the secret value is passed to the set command: "args" (default), "stdin", "env" or "fifo".
+	// Passing the value in the arguments exposes it to other processes.
+	ValueVia string `json:"value_via,omitempty"`
+	// ValueEnv is the environment variable that holds the secret value when it is passed via "env".
+	// Defaults to VAULT_SECRET_VALUE.
+	ValueEnv string `json:"value_env,omitempty"`
+	// Errors map failures of the command to vault errors, in order of precedence
+	Errors []ErrorRule `json:"errors,omitempty"`
 }
 
 // ExternalConfig contains external (cli command-based) vault configuration
@@ -205,6 +344,13 @@ type ExternalConfig struct {
 	Exists CommandConfig `json:"exists,omitempty"`
 	// Metadata CommandConfig for the metadata operation
 	Metadata CommandConfig `json:"metadata,omitempty"`
+	// Unlock CommandConfig that starts a session before the first operation, and again when a command fails with
+	// a "session_expired" error. Its output is available to later templates as {{session}}.
+	Unlock CommandConfig `json:"unlock,omitempty"`
+	// Lock CommandConfig that ends the session when the provider is closed
+	Lock CommandConfig `json:"lock,omitempty"`
+	// SessionEnv is the environment variable that passes the session to later commands
+	SessionEnv string `json:"session_env,omitempty"`
 
 	// Environment variables for commands
 	Environment map[string]string `json:"environment,omitempty"`
@@ -214,25 +360,66 @@ type ExternalConfig struct {
 
 	// WorkingDir for command execution
 	WorkingDir string `json:"working_dir,omitempty"`
+
+	// Retry policy for failed commands
+	Retry *RetryConfig `json:"retry,omitempty"`
+
+	// CircuitBreaker stops running commands after repeated failures
+	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+
+	// Plugin runs the provider as a plugin process instead of running a command for each operation. The
+	// operation commands are ignored when it is set.
+	Plugin *PluginConfig `json:"plugin,omitempty"`
 }
 
 func (c *ExternalConfig) Validate() error {
+	if c.Retry != nil {
+		if err := c.Retry.validate(); err != nil {
+			return err
+		}
+	}
+	if c.CircuitBreaker != nil {
+		if err := c.CircuitBreaker.validate(); err != nil {
+			return err
+		}
+	}
+	if c.Plugin != nil {
+		return c.Plugin.Validate()
+	}
 	if c.Get.CommandTemplate == "" || c.Set.CommandTemplate == "" {
 		return fmt.Errorf("%w: get and set args template required for external vault", ErrInvalidConfig)
 	}
-	return nil
+	for _, cmd := range c.commands() {
+		if err := cmd.validateErrorRules(); err != nil {
+			return err
+		}
+	}
+	if err := c.validateSession(); err != nil {
+		return err
+	}
+	return c.Set.validateValueDelivery()
+}
+
+// commands returns the configured operation commands
+func (c *ExternalConfig) commands() []*CommandConfig {
+	return []*CommandConfig{&c.Get, &c.Set, &c.Delete, &c.List, &c.Exists, &c.Metadata, &c.Unlock, &c.Lock}
 }
 
 // UnencryptedConfig contains unencrypted (plain text) vault configuration
 type UnencryptedConfig struct {
 	// Storage location for the vault file
 	StoragePath string `json:"storage_path"`
+	// HistorySize is the number of prior versions kept for each secret
+	HistorySize int `json:"history_size,omitempty"`
 }
 
 func (c *UnencryptedConfig) Validate() error {
 	if c.StoragePath == "" {
 		return fmt.Errorf("%w: storage path is required for unencrypted vault", ErrInvalidConfig)
 	}
+	if err := validateHistorySize(c.HistorySize); err != nil {
+		return err
+	}
 	return nil
 }
 
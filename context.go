@@ -0,0 +1,66 @@
+package vault
+
+import (
+	"context"
+)
+
+// ProviderContext is implemented by providers that accept a context for each secret operation. The context's
+// cancellation and deadline are propagated to the underlying storage (external commands, keyring calls, file I/O).
+type ProviderContext interface {
+	Provider
+
+	GetSecretContext(ctx context.Context, key string) (Secret, error)
+	SetSecretContext(ctx context.Context, key string, value Secret) error
+	DeleteSecretContext(ctx context.Context, key string) error
+	ListSecretsContext(ctx context.Context) ([]string, error)
+	HasSecretContext(ctx context.Context, key string) (bool, error)
+}
+
+// AsProviderContext returns a context-aware view of the provided vault. Providers that natively implement
+// ProviderContext are returned as-is; any other provider is wrapped in an adapter that checks the context
+// before delegating to the context-free method.
+func AsProviderContext(v Provider) ProviderContext {
+	if pc, ok := v.(ProviderContext); ok {
+		return pc
+	}
+	return &providerContextAdapter{Provider: v}
+}
+
+type providerContextAdapter struct {
+	Provider
+}
+
+func (a *providerContextAdapter) GetSecretContext(ctx context.Context, key string) (Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.GetSecret(key)
+}
+
+func (a *providerContextAdapter) SetSecretContext(ctx context.Context, key string, value Secret) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.SetSecret(key, value)
+}
+
+func (a *providerContextAdapter) DeleteSecretContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return a.DeleteSecret(key)
+}
+
+func (a *providerContextAdapter) ListSecretsContext(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return a.ListSecrets()
+}
+
+func (a *providerContextAdapter) HasSecretContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return a.HasSecret(key)
+}
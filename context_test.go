@@ -0,0 +1,119 @@
+package vault_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/flowexec/vault"
+)
+
+// legacyProvider hides the context-aware methods of the wrapped provider
+type legacyProvider struct {
+	vault.Provider
+}
+
+func TestAsProviderContext(t *testing.T) {
+	tempDir := t.TempDir()
+	v := setupAESVault(t, tempDir)
+	defer v.Close()
+
+	if _, ok := v.(vault.ProviderContext); !ok {
+		t.Fatal("AES256 vault should natively implement ProviderContext")
+	}
+	if pc := vault.AsProviderContext(v); pc != v {
+		t.Error("AsProviderContext should return native implementations as-is")
+	}
+
+	pc := vault.AsProviderContext(&legacyProvider{Provider: v})
+	ctx := context.Background()
+	if err := pc.SetSecretContext(ctx, "adapted", vault.NewSecretValue([]byte("value"))); err != nil {
+		t.Fatalf("Failed to set secret through adapter: %v", err)
+	}
+	secret, err := pc.GetSecretContext(ctx, "adapted")
+	if err != nil {
+		t.Fatalf("Failed to get secret through adapter: %v", err)
+	}
+	if secret.PlainTextString() != "value" {
+		t.Errorf("Expected 'value', got %q", secret.PlainTextString())
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := pc.GetSecretContext(cancelled, "adapted"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from adapter, got: %v", err)
+	}
+	if err := pc.DeleteSecretContext(cancelled, "adapted"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from adapter, got: %v", err)
+	}
+	if exists, _ := pc.HasSecretContext(ctx, "adapted"); !exists {
+		t.Error("Secret should not be deleted with a cancelled context")
+	}
+}
+
+func TestProviderContextCancellation(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string) vault.Provider
+	}{
+		{name: "AES256 Vault", setup: setupAESVault},
+		{name: "Age Vault", setup: setupAgeVault},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.setup(t, t.TempDir())
+			defer v.Close()
+			pc := vault.AsProviderContext(v)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			err := pc.SetSecretContext(ctx, "key", vault.NewSecretValue([]byte("value")))
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context.Canceled, got: %v", err)
+			}
+			if _, err := pc.ListSecretsContext(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("Expected context.Canceled, got: %v", err)
+			}
+			if exists, _ := v.HasSecret("key"); exists {
+				t.Error("Secret should not be written with a cancelled context")
+			}
+		})
+	}
+}
+
+func TestExternalVaultProvider_ContextPropagation(t *testing.T) {
+	provider, err := vault.NewExternalVaultProvider(&vault.Config{
+		ID:   "test-vault",
+		Type: vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{
+			Get: vault.CommandConfig{CommandTemplate: "get {{key}}"},
+			Set: vault.CommandConfig{CommandTemplate: "set {{key}}"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	var gotDeadline bool
+	provider.SetExecutionFunc(func(ctx context.Context, cmd, input, dir string, envList []string) (string, error) {
+		_, gotDeadline = ctx.Deadline()
+		return "value", ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	if _, err := provider.GetSecretContext(ctx, "key"); err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if !gotDeadline {
+		t.Error("Expected the caller's deadline to reach the command executor")
+	}
+
+	cancel()
+	if _, err := provider.GetSecretContext(ctx, "key"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got: %v", err)
+	}
+}
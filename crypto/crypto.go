@@ -7,8 +7,6 @@ import (
 	"encoding/base64"
 	"fmt"
 	"io"
-
-	"golang.org/x/crypto/scrypt"
 )
 
 // GenerateKey generates a random 32 byte key and returns it as a base64 encoded string.
@@ -21,23 +19,25 @@ func GenerateKey() (string, error) {
 	return EncodeValue(key), nil
 }
 
+// ScryptParams are the scrypt cost parameters used to derive a key from a password.
+type ScryptParams struct {
+	N int `json:"n"`
+	R int `json:"r"`
+	P int `json:"p"`
+}
+
+// DefaultScryptParams are the cost parameters used by DeriveKey.
+var DefaultScryptParams = ScryptParams{N: 1048576, R: 8, P: 1}
+
 // DeriveKey derives a 32 byte key from the provided password and salt and returns
 // the key and salt as base64 encoded strings.
 // If salt is nil, a random salt will be generated.
 func DeriveKey(password, salt []byte) (string, string, error) {
-	if salt == nil {
-		salt = make([]byte, 32)
-		if _, err := rand.Read(salt); err != nil {
-			return "", "", err
-		}
-	}
-
-	key, err := scrypt.Key(password, salt, 1048576, 8, 1, 32)
+	key, params, err := DeriveKeyWithKDF(Scrypt(DefaultScryptParams), password, salt)
 	if err != nil {
 		return "", "", err
 	}
-
-	return EncodeValue(key), EncodeValue(salt), nil
+	return key, params.Salt, nil
 }
 
 // EncodeValue encodes a byte slice as a base64 encoded string.
@@ -57,6 +57,12 @@ func DecodeValue(s string) ([]byte, error) {
 // EncryptValue encrypts a string using AES-256-GCM and returns the encrypted value as a base64 encoded string.
 // The encryption key used for encryption must be a base64 encoded string.
 func EncryptValue(encryptionKey string, text string) (string, error) {
+	return EncryptValueWithAAD(encryptionKey, text, nil)
+}
+
+// EncryptValueWithAAD encrypts a string using AES-256-GCM, authenticating the additional data along with it, and
+// returns the encrypted value as a base64 encoded string. The same additional data must be provided to decrypt it.
+func EncryptValueWithAAD(encryptionKey string, text string, aad []byte) (string, error) {
 	decodedMasterKey, err := DecodeValue(encryptionKey)
 	if err != nil {
 		return "", fmt.Errorf("error decoding master key: %w", err)
@@ -81,13 +87,19 @@ func EncryptValue(encryptionKey string, text string) (string, error) {
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return "", fmt.Errorf("error reading random bytes: %w", err)
 	}
-	ciphertext := gcm.Seal(nonce, nonce, plaintext, nil)
+	ciphertext := gcm.Seal(nonce, nonce, plaintext, aad)
 	return EncodeValue(ciphertext), nil
 }
 
 // DecryptValue decrypts a string using AES-256-GCM and returns the decrypted value as a string.
 // The master key used for decryption must be a base64 encoded string.
 func DecryptValue(encryptionKey string, text string) (string, error) {
+	return DecryptValueWithAAD(encryptionKey, text, nil)
+}
+
+// DecryptValueWithAAD decrypts a string encrypted with EncryptValueWithAAD. Decryption fails if the additional
+// data does not match the data provided at encryption.
+func DecryptValueWithAAD(encryptionKey string, text string, aad []byte) (string, error) {
 	decodedMasterKey, err := DecodeValue(encryptionKey)
 	if err != nil {
 		return "", fmt.Errorf("error decoding master key: %w", err)
@@ -113,7 +125,7 @@ func DecryptValue(encryptionKey string, text string) (string, error) {
 	}
 
 	nonce, ciphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, aad)
 	if err != nil {
 		return "", fmt.Errorf("decryption failed: %w", err)
 	}
@@ -147,6 +147,33 @@ func TestEncryptDecryptValue(t *testing.T) {
 	}
 }
 
+func TestEncryptDecryptValueWithAAD(t *testing.T) {
+	masterKey, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate master key: %v", err)
+	}
+
+	encrypted, err := crypto.EncryptValueWithAAD(masterKey, "test value", []byte("vault-a"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	decrypted, err := crypto.DecryptValueWithAAD(masterKey, encrypted, []byte("vault-a"))
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %v", err)
+	}
+	if decrypted != "test value" {
+		t.Errorf("Expected %q, got %q", "test value", decrypted)
+	}
+
+	if _, err := crypto.DecryptValueWithAAD(masterKey, encrypted, []byte("vault-b")); err == nil {
+		t.Error("Expected decryption with mismatched additional data to fail")
+	}
+	if _, err := crypto.DecryptValue(masterKey, encrypted); err == nil {
+		t.Error("Expected decryption without additional data to fail")
+	}
+}
+
 func TestEncryptionUniqueness(t *testing.T) {
 	masterKey, err := crypto.GenerateKey()
 	if err != nil {
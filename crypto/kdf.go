@@ -0,0 +1,161 @@
+package crypto
+
+import (
+	"crypto/rand"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Supported key derivation algorithms.
+const (
+	KDFScrypt   = "scrypt"
+	KDFArgon2id = "argon2id"
+)
+
+const derivedKeySize = 32
+
+// Upper bounds on the KDF cost parameters. Parameters are read from vault files, so without a limit a corrupted or
+// crafted file could make deriving a key allocate gigabytes of memory or run for minutes.
+const (
+	maxScryptN       = 1 << 22
+	maxScryptRP      = 32
+	maxKDFMemory     = 4 << 30 // bytes
+	maxArgon2Time    = 64
+	maxArgon2Memory  = maxKDFMemory / 1024 // KiB
+	scryptBlockBytes = 128
+)
+
+// Argon2Params are the Argon2id cost parameters used to derive a key from a password.
+// Memory is given in KiB.
+type Argon2Params struct {
+	Time    uint32 `json:"t"`
+	Memory  uint32 `json:"m"`
+	Threads uint8  `json:"p"`
+}
+
+// DefaultArgon2Params are the Argon2id cost parameters recommended by RFC 9106 for memory-constrained environments.
+var DefaultArgon2Params = Argon2Params{Time: 3, Memory: 64 * 1024, Threads: 4}
+
+// KDFParams describe how a key is derived from a password. They are recorded alongside the derived key so the
+// same key can be derived again. Only the parameters for the selected algorithm are set.
+type KDFParams struct {
+	Algorithm string        `json:"alg"`
+	Salt      string        `json:"salt,omitempty"`
+	Scrypt    *ScryptParams `json:"scrypt,omitempty"`
+	Argon2id  *Argon2Params `json:"argon2id,omitempty"`
+}
+
+// KDF derives keys from passwords.
+type KDF interface {
+	// Params returns the algorithm and cost parameters of the KDF, without a salt.
+	Params() KDFParams
+	// Derive derives a 32 byte key from the password and salt.
+	Derive(password, salt []byte) ([]byte, error)
+}
+
+// Scrypt returns a KDF that uses scrypt with the given cost parameters.
+func Scrypt(params ScryptParams) KDF {
+	return scryptKDF(params)
+}
+
+// Argon2id returns a KDF that uses Argon2id with the given cost parameters.
+func Argon2id(params Argon2Params) KDF {
+	return argon2KDF(params)
+}
+
+// NewKDF returns the KDF described by the parameters. Algorithm parameters that are not set fall back to the
+// algorithm defaults. Parameters beyond the supported cost are rejected.
+func NewKDF(params KDFParams) (KDF, error) {
+	switch params.Algorithm {
+	case KDFScrypt, "":
+		p := DefaultScryptParams
+		if params.Scrypt != nil {
+			p = *params.Scrypt
+		}
+		if p.N <= 1 || p.N&(p.N-1) != 0 || p.R <= 0 || p.P <= 0 {
+			return nil, fmt.Errorf("invalid scrypt parameters")
+		}
+		if p.N > maxScryptN || p.R > maxScryptRP || p.P > maxScryptRP || p.R*p.P > maxScryptRP ||
+			uint64(scryptBlockBytes)*uint64(p.N)*uint64(p.R) > maxKDFMemory {
+			return nil, fmt.Errorf("scrypt parameters exceed the supported cost")
+		}
+		return Scrypt(p), nil
+	case KDFArgon2id:
+		p := DefaultArgon2Params
+		if params.Argon2id != nil {
+			p = *params.Argon2id
+		}
+		if p.Time == 0 || p.Threads == 0 || p.Memory < 8*uint32(p.Threads) {
+			return nil, fmt.Errorf("invalid argon2id parameters")
+		}
+		// threads are bounded by the uint8 field
+		if p.Time > maxArgon2Time || p.Memory > maxArgon2Memory {
+			return nil, fmt.Errorf("argon2id parameters exceed the supported cost")
+		}
+		return Argon2id(p), nil
+	default:
+		return nil, fmt.Errorf("unsupported key derivation function %s", params.Algorithm)
+	}
+}
+
+// DeriveKeyWithKDF derives a 32 byte key from the provided password and salt using the KDF and returns the key
+// as a base64 encoded string, along with the parameters needed to derive it again.
+// If salt is nil, a random salt will be generated.
+func DeriveKeyWithKDF(kdf KDF, password, salt []byte) (string, KDFParams, error) {
+	if salt == nil {
+		salt = make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return "", KDFParams{}, err
+		}
+	}
+
+	key, err := kdf.Derive(password, salt)
+	if err != nil {
+		return "", KDFParams{}, err
+	}
+
+	params := kdf.Params()
+	params.Salt = EncodeValue(salt)
+	return EncodeValue(key), params, nil
+}
+
+// RederiveKey derives the key described by previously recorded parameters and returns it as a base64 encoded string.
+func RederiveKey(password []byte, params KDFParams) (string, error) {
+	kdf, err := NewKDF(params)
+	if err != nil {
+		return "", err
+	}
+	salt, err := DecodeValue(params.Salt)
+	if err != nil {
+		return "", fmt.Errorf("error decoding salt: %w", err)
+	}
+	key, err := kdf.Derive(password, salt)
+	if err != nil {
+		return "", err
+	}
+	return EncodeValue(key), nil
+}
+
+type scryptKDF ScryptParams
+
+func (k scryptKDF) Params() KDFParams {
+	p := ScryptParams(k)
+	return KDFParams{Algorithm: KDFScrypt, Scrypt: &p}
+}
+
+func (k scryptKDF) Derive(password, salt []byte) ([]byte, error) {
+	return scrypt.Key(password, salt, k.N, k.R, k.P, derivedKeySize)
+}
+
+type argon2KDF Argon2Params
+
+func (k argon2KDF) Params() KDFParams {
+	p := Argon2Params(k)
+	return KDFParams{Algorithm: KDFArgon2id, Argon2id: &p}
+}
+
+func (k argon2KDF) Derive(password, salt []byte) ([]byte, error) {
+	return argon2.IDKey(password, salt, k.Time, k.Memory, k.Threads, derivedKeySize), nil
+}
@@ -0,0 +1,137 @@
+package crypto_test
+
+import (
+	"testing"
+
+	"github.com/flowexec/vault/crypto"
+)
+
+func TestDeriveKeyWithKDF(t *testing.T) {
+	kdfs := []crypto.KDF{
+		crypto.Scrypt(crypto.ScryptParams{N: 1024, R: 8, P: 1}),
+		crypto.Argon2id(crypto.Argon2Params{Time: 1, Memory: 1024, Threads: 1}),
+	}
+
+	for _, kdf := range kdfs {
+		t.Run(kdf.Params().Algorithm, func(t *testing.T) {
+			key, params, err := crypto.DeriveKeyWithKDF(kdf, []byte("password"), nil)
+			if err != nil {
+				t.Fatalf("Failed to derive key: %v", err)
+			}
+			if params.Salt == "" {
+				t.Error("Expected a random salt to be recorded")
+			}
+
+			rederived, err := crypto.RederiveKey([]byte("password"), params)
+			if err != nil {
+				t.Fatalf("Failed to rederive key: %v", err)
+			}
+			if rederived != key {
+				t.Error("Rederived key should match the original key")
+			}
+
+			other, err := crypto.RederiveKey([]byte("other password"), params)
+			if err != nil {
+				t.Fatalf("Failed to rederive key: %v", err)
+			}
+			if other == key {
+				t.Error("Keys derived from different passwords should differ")
+			}
+
+			if _, err := crypto.EncryptValue(key, "test"); err != nil {
+				t.Errorf("Derived key should be usable for encryption: %v", err)
+			}
+		})
+	}
+}
+
+func TestRederiveKeyParams(t *testing.T) {
+	salt := crypto.EncodeValue([]byte("0123456789abcdef0123456789abcdef"))
+	params := crypto.KDFParams{Algorithm: crypto.KDFScrypt, Salt: salt, Scrypt: &crypto.ScryptParams{N: 1024, R: 8, P: 1}}
+
+	key1, err := crypto.RederiveKey([]byte("password"), params)
+	if err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	key2, err := crypto.RederiveKey([]byte("password"), params)
+	if err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	if key1 != key2 {
+		t.Error("Keys derived with same password, salt and params should be identical")
+	}
+
+	params.Scrypt = &crypto.ScryptParams{N: 2048, R: 8, P: 1}
+	key3, err := crypto.RederiveKey([]byte("password"), params)
+	if err != nil {
+		t.Fatalf("Failed to derive key: %v", err)
+	}
+	if key1 == key3 {
+		t.Error("Keys derived with different params should differ")
+	}
+
+	params.Scrypt = &crypto.ScryptParams{N: 1000, R: 8, P: 1}
+	if _, err := crypto.RederiveKey([]byte("password"), params); err == nil {
+		t.Error("Expected invalid scrypt params to be rejected")
+	}
+}
+
+func TestNewKDF(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  crypto.KDFParams
+		wantErr bool
+	}{
+		{name: "default", params: crypto.KDFParams{}},
+		{name: "argon2id defaults", params: crypto.KDFParams{Algorithm: crypto.KDFArgon2id}},
+		{
+			name:    "invalid scrypt cost",
+			params:  crypto.KDFParams{Algorithm: crypto.KDFScrypt, Scrypt: &crypto.ScryptParams{N: 1000, R: 8, P: 1}},
+			wantErr: true,
+		},
+		{
+			name: "invalid argon2id memory",
+			params: crypto.KDFParams{
+				Algorithm: crypto.KDFArgon2id,
+				Argon2id:  &crypto.Argon2Params{Time: 1, Memory: 1, Threads: 4},
+			},
+			wantErr: true,
+		},
+		{
+			name:    "oversized scrypt cost",
+			params:  crypto.KDFParams{Algorithm: crypto.KDFScrypt, Scrypt: &crypto.ScryptParams{N: 1 << 23, R: 8, P: 1}},
+			wantErr: true,
+		},
+		{
+			name:    "oversized scrypt parallelism",
+			params:  crypto.KDFParams{Algorithm: crypto.KDFScrypt, Scrypt: &crypto.ScryptParams{N: 1024, R: 8, P: 1 << 20}},
+			wantErr: true,
+		},
+		{
+			name: "oversized argon2id memory",
+			params: crypto.KDFParams{
+				Algorithm: crypto.KDFArgon2id,
+				Argon2id:  &crypto.Argon2Params{Time: 1, Memory: 1 << 31, Threads: 4},
+			},
+			wantErr: true,
+		},
+		{
+			name: "oversized argon2id time",
+			params: crypto.KDFParams{
+				Algorithm: crypto.KDFArgon2id,
+				Argon2id:  &crypto.Argon2Params{Time: 1 << 20, Memory: 1024, Threads: 1},
+			},
+			wantErr: true,
+		},
+		{name: "unknown algorithm", params: crypto.KDFParams{Algorithm: "pbkdf2"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := crypto.NewKDF(tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("NewKDF() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
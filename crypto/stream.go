@@ -0,0 +1,211 @@
+package crypto
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// StreamChunkSize is the size of the plaintext chunks encrypted by EncryptStream.
+const StreamChunkSize = 64 * 1024
+
+// Streams are encrypted in the STREAM construction: the plaintext is split into chunks that are each sealed with
+// AES-256-GCM. The nonce of each chunk is a random prefix shared by the stream, followed by the chunk counter and
+// a flag marking the final chunk, so chunks cannot be reordered, dropped, or truncated without detection.
+//
+//	nonce prefix (7 bytes) | chunk 0 | chunk 1 | ... | final chunk
+const (
+	streamPrefixSize = 7
+	streamLastChunk  = 1
+)
+
+var errStreamClosed = errors.New("stream is closed")
+
+// EncryptStream returns a writer that encrypts the data written to it with AES-256-GCM and writes the ciphertext
+// to w. The additional data is authenticated with every chunk. Close must be called to write the final chunk; it
+// does not close w. The encryption key must be a base64 encoded string.
+func EncryptStream(w io.Writer, encryptionKey string, aad []byte) (io.WriteCloser, error) {
+	gcm, err := newStreamCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, fmt.Errorf("error reading random bytes: %w", err)
+	}
+	if _, err := w.Write(prefix); err != nil {
+		return nil, fmt.Errorf("error writing stream header: %w", err)
+	}
+
+	return &streamWriter{
+		w:      w,
+		gcm:    gcm,
+		aad:    aad,
+		prefix: prefix,
+		buf:    make([]byte, 0, StreamChunkSize),
+	}, nil
+}
+
+// DecryptStream returns a reader that decrypts a stream written by EncryptStream from r. The same additional data
+// must be provided. Reads return an error if the stream has been modified or truncated, so data read before an
+// error must not be trusted. The encryption key must be a base64 encoded string.
+func DecryptStream(r io.Reader, encryptionKey string, aad []byte) (io.Reader, error) {
+	gcm, err := newStreamCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("error reading stream header: %w", err)
+	}
+
+	return &streamReader{
+		r:      bufio.NewReader(r),
+		gcm:    gcm,
+		aad:    aad,
+		prefix: prefix,
+		chunk:  make([]byte, StreamChunkSize+gcm.Overhead()),
+	}, nil
+}
+
+func newStreamCipher(encryptionKey string) (cipher.AEAD, error) {
+	decodedMasterKey, err := DecodeValue(encryptionKey)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding master key: %w", err)
+	}
+	block, err := aes.NewCipher(decodedMasterKey)
+	if err != nil {
+		return nil, fmt.Errorf("error creating new cipher: %w", err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating GCM: %w", err)
+	}
+	return gcm, nil
+}
+
+func streamNonce(prefix []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, 0, streamPrefixSize+5)
+	nonce = append(nonce, prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if last {
+		return append(nonce, streamLastChunk)
+	}
+	return append(nonce, 0)
+}
+
+type streamWriter struct {
+	w       io.Writer
+	gcm     cipher.AEAD
+	aad     []byte
+	prefix  []byte
+	counter uint32
+	buf     []byte
+	closed  bool
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if s.closed {
+		return 0, errStreamClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// a full chunk is only sealed once more data arrives, since the final chunk must be marked as such
+		if len(s.buf) == StreamChunkSize {
+			if err := s.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(s.buf[len(s.buf):StreamChunkSize], p)
+		s.buf = s.buf[:len(s.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (s *streamWriter) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.flush(true)
+}
+
+func (s *streamWriter) flush(last bool) error {
+	if s.counter == math.MaxUint32 {
+		return fmt.Errorf("stream too long to encrypt")
+	}
+	sealed := s.gcm.Seal(nil, streamNonce(s.prefix, s.counter, last), s.buf, s.aad)
+	if _, err := s.w.Write(sealed); err != nil {
+		return fmt.Errorf("error writing stream chunk: %w", err)
+	}
+	s.counter++
+	s.buf = s.buf[:0]
+	return nil
+}
+
+type streamReader struct {
+	r       *bufio.Reader
+	gcm     cipher.AEAD
+	aad     []byte
+	prefix  []byte
+	counter uint32
+	chunk   []byte
+	plain   []byte
+	done    bool
+	err     error
+}
+
+func (s *streamReader) Read(p []byte) (int, error) {
+	for len(s.plain) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.done {
+			return 0, io.EOF
+		}
+		s.err = s.next()
+	}
+
+	n := copy(p, s.plain)
+	s.plain = s.plain[n:]
+	return n, nil
+}
+
+// next reads and decrypts the next chunk
+func (s *streamReader) next() error {
+	n, err := io.ReadFull(s.r, s.chunk)
+	switch {
+	case errors.Is(err, io.EOF):
+		return fmt.Errorf("decryption failed: stream truncated")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		s.done = true
+	case err != nil:
+		return fmt.Errorf("error reading stream chunk: %w", err)
+	default:
+		if _, err := s.r.Peek(1); errors.Is(err, io.EOF) {
+			s.done = true
+		}
+	}
+
+	plain, err := s.gcm.Open(s.chunk[:0], streamNonce(s.prefix, s.counter, s.done), s.chunk[:n], s.aad)
+	if err != nil {
+		return fmt.Errorf("decryption failed: %w", err)
+	}
+	if s.counter == math.MaxUint32 {
+		return fmt.Errorf("decryption failed: stream too long")
+	}
+	s.counter++
+	s.plain = plain
+	return nil
+}
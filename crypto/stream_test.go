@@ -0,0 +1,93 @@
+package crypto_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+
+	"github.com/flowexec/vault/crypto"
+)
+
+func encryptStream(t *testing.T, key string, plaintext, aad []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := crypto.EncryptStream(&buf, key, aad)
+	if err != nil {
+		t.Fatalf("Failed to create encrypt stream: %v", err)
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		t.Fatalf("Failed to write plaintext: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Failed to close encrypt stream: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func decryptStream(key string, ciphertext, aad []byte) ([]byte, error) {
+	r, err := crypto.DecryptStream(bytes.NewReader(ciphertext), key, aad)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func TestEncryptDecryptStream(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	chunk := crypto.StreamChunkSize
+	sizes := []int{0, 1, chunk - 1, chunk, chunk + 1, 3*chunk + 7}
+	for _, size := range sizes {
+		plaintext := make([]byte, size)
+		_, _ = rand.Read(plaintext)
+
+		ciphertext := encryptStream(t, key, plaintext, []byte("aad"))
+		decrypted, err := decryptStream(key, ciphertext, []byte("aad"))
+		if err != nil {
+			t.Fatalf("Failed to decrypt %d byte stream: %v", size, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("Decrypted %d byte stream does not match plaintext", size)
+		}
+	}
+}
+
+func TestDecryptStreamTampering(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	plaintext := bytes.Repeat([]byte("a"), 2*crypto.StreamChunkSize+100)
+	ciphertext := encryptStream(t, key, plaintext, []byte("aad"))
+	chunk := crypto.StreamChunkSize + 16
+
+	flipped := bytes.Clone(ciphertext)
+	flipped[len(flipped)-1] ^= 1
+	otherKey, _ := crypto.GenerateKey()
+
+	tests := []struct {
+		name       string
+		key        string
+		ciphertext []byte
+		aad        []byte
+	}{
+		{name: "wrong key", key: otherKey, ciphertext: ciphertext, aad: []byte("aad")},
+		{name: "wrong additional data", key: key, ciphertext: ciphertext, aad: []byte("other")},
+		{name: "modified chunk", key: key, ciphertext: flipped, aad: []byte("aad")},
+		{name: "truncated at chunk boundary", key: key, ciphertext: ciphertext[:7+2*chunk], aad: []byte("aad")},
+		{name: "final chunk dropped", key: key, ciphertext: ciphertext[:7+chunk], aad: []byte("aad")},
+		{name: "header only", key: key, ciphertext: ciphertext[:7], aad: []byte("aad")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decryptStream(tt.key, tt.ciphertext, tt.aad); err == nil {
+				t.Error("Expected decryption to fail")
+			}
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 var (
 	ErrSecretNotFound   = errors.New("secret not found")
+	ErrSecretExpired    = errors.New("secret expired")
 	ErrInvalidKey       = errors.New("invalid secret key")
 	ErrNoAccess         = errors.New("access denied")
 	ErrInvalidConfig    = errors.New("invalid configuration")
@@ -14,6 +15,16 @@ var (
 	ErrDecryptionFailed = errors.New("decryption failed")
 	ErrInvalidRecipient = errors.New("invalid recipient")
 	ErrPathNotSecure    = errors.New("path is not secure")
+	ErrNotSupported     = errors.New("operation not supported")
+	// ErrProviderUnavailable is returned when the backing secret store cannot be reached, e.g. because an
+	// external command timed out or its service is down
+	ErrProviderUnavailable = errors.New("provider unavailable")
+	// ErrSessionExpired is returned when an external command fails because its session has expired and unlocking
+	// again did not help
+	ErrSessionExpired = errors.New("session expired")
+	// ErrCircuitOpen is returned by an external provider that has stopped running commands after repeated
+	// failures. The returned error is a *CircuitOpenError.
+	ErrCircuitOpen = errors.New("circuit breaker open")
 )
 
 type VaultPathError struct {
@@ -37,6 +37,10 @@ func main() {
 	fmt.Printf("Provider ID: %s\n", config.ID)
 	fmt.Println()
 
+	for _, warning := range config.External.Warnings() {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+
 	if err := checkEnvironmentVariables(configPath); err != nil {
 		fmt.Printf("Warning: Could not check environment variables: %v\n", err)
 	}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"sync"
@@ -13,15 +14,24 @@ import (
 	"mvdan.cc/sh/v3/expand"
 	"mvdan.cc/sh/v3/interp"
 	"mvdan.cc/sh/v3/syntax"
+
+	"github.com/flowexec/vault/plugin"
 )
 
 type ExternalVaultProvider struct {
-	ctx     context.Context
 	mu      sync.RWMutex
 	id      string
 	execute func(ctx context.Context, cmd, input, dir string, envList []string) (string, error)
 
-	cfg *ExternalConfig
+	cfg     *ExternalConfig
+	plugin  *plugin.Client
+	retry   retryPolicy
+	breaker *circuitBreaker
+
+	// unlockMu serializes unlocking and locking, while sessionMu guards the session itself
+	unlockMu  sync.Mutex
+	sessionMu sync.RWMutex
+	session   externalSession
 }
 
 func NewExternalVaultProvider(cfg *Config) (*ExternalVaultProvider, error) {
@@ -29,13 +39,35 @@ func NewExternalVaultProvider(cfg *Config) (*ExternalVaultProvider, error) {
 		return nil, fmt.Errorf("external configuration is required")
 	}
 
+	// the provider keeps its own copy of the configuration with the error rules compiled
+	external := *cfg.External
 	vault := &ExternalVaultProvider{
-		ctx:     context.Background(),
 		id:      cfg.ID,
-		cfg:     cfg.External,
+		cfg:     &external,
 		execute: execute,
 	}
 
+	if err := vault.cfg.Set.validateValueDelivery(); err != nil {
+		return nil, err
+	}
+	for _, cmd := range vault.cfg.commands() {
+		if err := cmd.compileErrorRules(); err != nil {
+			return nil, err
+		}
+	}
+	var err error
+	if vault.retry, err = newRetryPolicy(cfg.External.Retry); err != nil {
+		return nil, err
+	}
+	if vault.breaker, err = newCircuitBreaker(cfg.External.CircuitBreaker); err != nil {
+		return nil, err
+	}
+	if vault.cfg.Plugin != nil {
+		if err := vault.startPlugin(); err != nil {
+			return nil, err
+		}
+	}
+
 	return vault, nil
 }
 
@@ -44,6 +76,14 @@ func (v *ExternalVaultProvider) ID() string {
 }
 
 func (v *ExternalVaultProvider) GetSecret(key string) (Secret, error) {
+	return v.GetSecretContext(context.Background(), key)
+}
+
+func (v *ExternalVaultProvider) GetSecretContext(ctx context.Context, key string) (Secret, error) {
+	if v.plugin != nil {
+		return v.pluginGetSecret(ctx, key)
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -55,20 +95,7 @@ func (v *ExternalVaultProvider) GetSecret(key string) (Secret, error) {
 		return nil, fmt.Errorf("get operation not configured")
 	}
 
-	cmd, err := v.renderCmdTemplate(v.cfg.Get.CommandTemplate, key)
-	if err != nil {
-		return nil, fmt.Errorf("failed to render get cmd: %w", err)
-	}
-
-	var input string
-	if v.cfg.Get.InputTemplate != "" {
-		input, err = v.renderInputTemplate(v.cfg.Get.InputTemplate, key)
-		if err != nil {
-			return nil, fmt.Errorf("failed to render input template: %w", err)
-		}
-	}
-
-	output, err := v.executeCommand(cmd, input)
+	output, err := v.runOperation(ctx, "get", v.cfg.Get, key)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get secret: %w", err)
 	}
@@ -87,6 +114,14 @@ func (v *ExternalVaultProvider) GetSecret(key string) (Secret, error) {
 }
 
 func (v *ExternalVaultProvider) SetSecret(key string, value Secret) error {
+	return v.SetSecretContext(context.Background(), key, value)
+}
+
+func (v *ExternalVaultProvider) SetSecretContext(ctx context.Context, key string, value Secret) error {
+	if v.plugin != nil {
+		return v.pluginSetSecret(ctx, key, value)
+	}
+
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
@@ -98,20 +133,7 @@ func (v *ExternalVaultProvider) SetSecret(key string, value Secret) error {
 		return fmt.Errorf("set operation not configured")
 	}
 
-	cmd, err := v.renderCmdTemplateWithValue(v.cfg.Set.CommandTemplate, key, value.PlainTextString())
-	if err != nil {
-		return fmt.Errorf("failed to render set cmd: %w", err)
-	}
-
-	var input string
-	if v.cfg.Set.InputTemplate != "" {
-		input, err = v.renderInputTemplate(v.cfg.Get.InputTemplate, key)
-		if err != nil {
-			return fmt.Errorf("failed to render input template: %w", err)
-		}
-	}
-
-	out, err := v.executeCommand(cmd, input)
+	out, err := v.setSecretCommand(ctx, key, value.PlainTextString())
 	if err != nil {
 		return fmt.Errorf("failed to set secret: %w stdErr: %s", err, out)
 	}
@@ -120,6 +142,14 @@ func (v *ExternalVaultProvider) SetSecret(key string, value Secret) error {
 }
 
 func (v *ExternalVaultProvider) DeleteSecret(key string) error {
+	return v.DeleteSecretContext(context.Background(), key)
+}
+
+func (v *ExternalVaultProvider) DeleteSecretContext(ctx context.Context, key string) error {
+	if v.plugin != nil {
+		return v.pluginDeleteSecret(ctx, key)
+	}
+
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
@@ -131,20 +161,7 @@ func (v *ExternalVaultProvider) DeleteSecret(key string) error {
 		return fmt.Errorf("delete operation not configured")
 	}
 
-	cmd, err := v.renderCmdTemplate(v.cfg.Delete.CommandTemplate, key)
-	if err != nil {
-		return fmt.Errorf("failed to render delete cmd: %w", err)
-	}
-
-	var input string
-	if v.cfg.Delete.InputTemplate != "" {
-		input, err = v.renderInputTemplate(v.cfg.Get.InputTemplate, key)
-		if err != nil {
-			return fmt.Errorf("failed to render input template: %w", err)
-		}
-	}
-
-	if _, err := v.executeCommand(cmd, input); err != nil {
+	if _, err := v.runOperation(ctx, "delete", v.cfg.Delete, key); err != nil {
 		return fmt.Errorf("failed to delete secret: %w", err)
 	}
 
@@ -152,6 +169,14 @@ func (v *ExternalVaultProvider) DeleteSecret(key string) error {
 }
 
 func (v *ExternalVaultProvider) ListSecrets() ([]string, error) {
+	return v.ListSecretsContext(context.Background())
+}
+
+func (v *ExternalVaultProvider) ListSecretsContext(ctx context.Context) ([]string, error) {
+	if v.plugin != nil {
+		return v.pluginListSecrets(ctx)
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -159,20 +184,7 @@ func (v *ExternalVaultProvider) ListSecrets() ([]string, error) {
 		return nil, fmt.Errorf("list operation not configured")
 	}
 
-	cmd, err := v.renderCmdTemplate(v.cfg.List.CommandTemplate, "")
-	if err != nil {
-		return nil, fmt.Errorf("failed to render list cmd: %w", err)
-	}
-
-	var input string
-	if v.cfg.List.InputTemplate != "" {
-		input, err = v.renderInputTemplate(v.cfg.Get.InputTemplate, "")
-		if err != nil {
-			return nil, fmt.Errorf("failed to render input template: %w", err)
-		}
-	}
-
-	output, err := v.executeCommand(cmd, input)
+	output, err := v.runOperation(ctx, "list", v.cfg.List, "")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list secrets: %w", err)
 	}
@@ -208,6 +220,14 @@ func (v *ExternalVaultProvider) ListSecrets() ([]string, error) {
 }
 
 func (v *ExternalVaultProvider) HasSecret(key string) (bool, error) {
+	return v.HasSecretContext(context.Background(), key)
+}
+
+func (v *ExternalVaultProvider) HasSecretContext(ctx context.Context, key string) (bool, error) {
+	if v.plugin != nil {
+		return v.pluginHasSecret(ctx, key)
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -216,38 +236,44 @@ func (v *ExternalVaultProvider) HasSecret(key string) (bool, error) {
 	}
 
 	if v.cfg.Exists.CommandTemplate != "" {
-		cmd, err := v.renderCmdTemplate(v.cfg.Exists.CommandTemplate, key)
-		if err != nil {
-			return false, fmt.Errorf("failed to render exists cmd: %w", err)
-		}
-
-		var input string
-		if v.cfg.Exists.InputTemplate != "" {
-			input, err = v.renderInputTemplate(v.cfg.Exists.InputTemplate, key)
-			if err != nil {
-				return false, fmt.Errorf("failed to render input template: %w", err)
-			}
+		_, err := v.runOperation(ctx, "exists", v.cfg.Exists, key)
+		switch {
+		case err == nil:
+			return true, nil
+		case errors.Is(err, ErrSecretNotFound), len(v.cfg.Exists.Errors) == 0:
+			// without error rules, any failure of the exists command is taken to mean the secret doesn't exist
+			return false, nil
+		default:
+			return false, err
 		}
-
-		_, err = v.executeCommand(cmd, input)
-		// typically, exists commands return non-zero exit code if secret doesn't exist
-		return err == nil, nil
 	}
 
-	_, err := v.GetSecret(key)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") ||
-			strings.Contains(err.Error(), "not exist") ||
-			strings.Contains(err.Error(), "not in") {
-			return false, nil
-		}
-		return false, err
+	_, err := v.GetSecretContext(ctx, key)
+	if err == nil {
+		return true, nil
+	}
+	if errors.Is(err, ErrSecretNotFound) {
+		return false, nil
+	}
+	if len(v.cfg.Get.Errors) == 0 && isNotFoundOutput(err.Error()) {
+		return false, nil
 	}
-	return true, nil
+	return false, err
+}
+
+// isNotFoundOutput guesses whether a command failed because the secret doesn't exist. It is only used for get
+// commands that do not declare error rules.
+func isNotFoundOutput(output string) bool {
+	return strings.Contains(output, "not found") ||
+		strings.Contains(output, "not exist") ||
+		strings.Contains(output, "not in")
 }
 
 func (v *ExternalVaultProvider) Close() error {
-	return nil
+	if v.plugin != nil {
+		return v.plugin.Close()
+	}
+	return v.lock(context.Background())
 }
 
 func (v *ExternalVaultProvider) SetExecutionFunc(
@@ -257,6 +283,10 @@ func (v *ExternalVaultProvider) SetExecutionFunc(
 }
 
 func (v *ExternalVaultProvider) Metadata() Metadata {
+	if v.plugin != nil {
+		return v.pluginMetadata()
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -264,19 +294,7 @@ func (v *ExternalVaultProvider) Metadata() Metadata {
 		return Metadata{}
 	}
 
-	cmd, err := v.renderCmdTemplate(v.cfg.Metadata.CommandTemplate, "")
-	if err != nil {
-		return Metadata{}
-	}
-	var input string
-	if v.cfg.List.InputTemplate != "" {
-		input, err = v.renderInputTemplate(v.cfg.Metadata.InputTemplate, "")
-		if err != nil {
-			return Metadata{}
-		}
-	}
-
-	output, err := v.executeCommand(cmd, input)
+	output, err := v.runOperation(context.Background(), "metadata", v.cfg.Metadata, "")
 	if err != nil {
 		return Metadata{}
 	}
@@ -294,20 +312,65 @@ func (v *ExternalVaultProvider) Metadata() Metadata {
 	return Metadata{RawData: metadataOutput}
 }
 
-func (v *ExternalVaultProvider) executeCommand(cmd, input string) (string, error) {
-	ctx := v.ctx
+// runOperation renders the command and input templates of the operation for the key and runs the command. The
+// templates are rendered again if the command is rerun with a new session.
+func (v *ExternalVaultProvider) runOperation(
+	ctx context.Context, name string, op CommandConfig, key string,
+) (string, error) {
+	return v.withSession(ctx, func(ctx context.Context) (string, error) {
+		return v.runCommandConfig(ctx, name, op, key)
+	})
+}
+
+// runCommandConfig renders the command and input templates for the key and runs the command
+func (v *ExternalVaultProvider) runCommandConfig(
+	ctx context.Context, name string, op CommandConfig, key string,
+) (string, error) {
+	cmd, err := v.renderCmdTemplate(op.CommandTemplate, key)
+	if err != nil {
+		return "", fmt.Errorf("failed to render %s cmd: %w", name, err)
+	}
+
+	var input string
+	if op.InputTemplate != "" {
+		input, err = v.renderInputTemplate(op.InputTemplate, key)
+		if err != nil {
+			return "", fmt.Errorf("failed to render input template: %w", err)
+		}
+	}
+
+	return v.executeCommand(ctx, op.Errors, cmd, input)
+}
+
+// executeCommand runs the command with the configured environment, along with any additional variables in env,
+// retrying it according to the retry policy
+func (v *ExternalVaultProvider) executeCommand(
+	ctx context.Context, rules []ErrorRule, cmd, input string, env ...string,
+) (string, error) {
+	return v.withRetry(ctx, func(ctx context.Context) (string, error) {
+		return v.runCommand(ctx, rules, cmd, input, env...)
+	})
+}
+
+// runCommand runs the command once, classifying failures with the error rules
+func (v *ExternalVaultProvider) runCommand(
+	ctx context.Context, rules []ErrorRule, cmd, input string, env ...string,
+) (string, error) {
 	if v.cfg.Timeout != "" {
 		var cancel context.CancelFunc
 		dur, parseErr := time.ParseDuration(v.cfg.Timeout)
 		if parseErr != nil {
 			return "", fmt.Errorf("invalid timeout duration: %w", parseErr)
 		}
-		ctx, cancel = context.WithTimeout(v.ctx, dur)
+		ctx, cancel = context.WithTimeout(ctx, dur)
 		defer cancel()
 	}
 
-	output, runErr := v.execute(ctx, cmd, input, v.cfg.WorkingDir, v.environmentToSlice())
+	output, runErr := v.execute(ctx, cmd, input, v.cfg.WorkingDir, append(v.environmentToSlice(), env...))
 	if runErr != nil {
+		if vaultErr := classifyCommandError(ctx, rules, runErr, output); vaultErr != nil {
+			return "", fmt.Errorf("%w: command failed: %w, stderr: %s", vaultErr, runErr, output)
+		}
 		return "", fmt.Errorf("command failed: %w, stderr: %s", runErr, output)
 	}
 
@@ -319,102 +382,73 @@ func (v *ExternalVaultProvider) environmentToSlice() []string {
 	for key, value := range expandEnv(v.cfg.Environment) {
 		envSlice = append(envSlice, fmt.Sprintf("%s=%s", key, value))
 	}
-	return envSlice
+	return append(envSlice, v.sessionEnv()...)
 }
 
 func (v *ExternalVaultProvider) renderCmdTemplate(template, key string) (string, error) {
-	data := map[string]interface{}{
-		"env":      expandEnv(v.cfg.Environment),
-		"key":      key,
-		"ref":      key,
-		"id":       key,
-		"name":     key,
-		"template": template,
-	}
-
-	template = os.ExpandEnv(template)
-	tmpl := expression.NewTemplate(fmt.Sprintf("%s-args-template", v.id), data)
-	err := tmpl.Parse(template)
-	if err != nil {
-		return "", fmt.Errorf("parsing args template: %w", err)
-	}
-
-	result, err := tmpl.ExecuteToString()
-	if err != nil {
-		return "", fmt.Errorf("evaluating args template: %w", err)
-	}
-	return result, nil
+	return v.renderTemplate("args", template, map[string]interface{}{"key": key}, nil)
 }
 
-func (v *ExternalVaultProvider) renderCmdTemplateWithValue(template, key, value string) (string, error) {
-	data := map[string]interface{}{
-		"env":      expandEnv(v.cfg.Environment),
-		"key":      key,
-		"ref":      key,
-		"id":       key,
-		"name":     key,
-		"value":    value,
-		"password": value,
-		"template": template,
-	}
-
-	template = os.ExpandEnv(template)
-	tmpl := expression.NewTemplate(fmt.Sprintf("%s-args-template", v.id), data)
-	err := tmpl.Parse(template)
-	if err != nil {
-		return "", fmt.Errorf("parsing args template: %w", err)
-	}
+func (v *ExternalVaultProvider) renderInputTemplate(template, input string) (string, error) {
+	return v.renderTemplate("input", template, map[string]interface{}{"input": input}, nil)
+}
 
-	result, err := tmpl.ExecuteToString()
-	if err != nil {
-		return "", fmt.Errorf("evaluating args template: %w", err)
-	}
-	return result, nil
+func (v *ExternalVaultProvider) renderOutputTemplate(template, output string) (string, error) {
+	return v.renderTemplate("output", template, map[string]interface{}{"output": output}, nil)
 }
 
-func (v *ExternalVaultProvider) renderInputTemplate(template, input string) (string, error) {
-	data := map[string]interface{}{
-		"env":      expandEnv(v.cfg.Environment),
-		"input":    input,
-		"template": template,
+// renderTemplate expands environment variables in the template, except those named in keepEnv, and evaluates it
+// with the given data along with the configured environment
+func (v *ExternalVaultProvider) renderTemplate(
+	kind, template string, data map[string]interface{}, keepEnv []string,
+) (string, error) {
+	if key, ok := data["key"]; ok {
+		for _, alias := range []string{"ref", "id", "name"} {
+			if _, set := data[alias]; !set {
+				data[alias] = key
+			}
+		}
 	}
+	data["env"] = expandEnv(v.cfg.Environment)
+	data["session"], _ = v.currentSession()
+	data["template"] = template
 
-	template = os.ExpandEnv(template)
-	tmpl := expression.NewTemplate(fmt.Sprintf("%s-input-template", v.id), data)
+	template = expandEnvExcept(template, keepEnv)
+	tmpl := expression.NewTemplate(fmt.Sprintf("%s-%s-template", v.id, kind), data)
 	err := tmpl.Parse(template)
 	if err != nil {
-		return "", fmt.Errorf("parsing input template: %w", err)
+		return "", fmt.Errorf("parsing %s template: %w", kind, err)
 	}
 
 	result, err := tmpl.ExecuteToString()
 	if err != nil {
-		return "", fmt.Errorf("evaluating input template: %w", err)
+		return "", fmt.Errorf("evaluating %s template: %w", kind, err)
 	}
 	return result, nil
 }
 
-func (v *ExternalVaultProvider) renderOutputTemplate(template, output string) (string, error) {
-	data := map[string]interface{}{
-		"env":      expandEnv(v.cfg.Environment),
-		"output":   output,
-		"template": template,
+func execute(ctx context.Context, cmd, input, dir string, envList []string) (string, error) {
+	if envList == nil {
+		envList = make([]string, 0)
 	}
+	envList = append(os.Environ(), envList...)
 
-	template = os.ExpandEnv(template)
-	tmpl := expression.NewTemplate(fmt.Sprintf("%s-output-template", v.id), data)
-	err := tmpl.Parse(template)
-	if err != nil {
-		return "", fmt.Errorf("parsing output template: %w", err)
+	stdOutBuffer := &strings.Builder{}
+	stdErrBuffer := &strings.Builder{}
+	if err := runShell(ctx, cmd, strings.NewReader(input), stdOutBuffer, stdErrBuffer, dir, envList); err != nil {
+		return stdErrBuffer.String(), err
 	}
-
-	result, err := tmpl.ExecuteToString()
-	if err != nil {
-		return "", fmt.Errorf("evaluating output template: %w", err)
+	output := stdOutBuffer.String()
+	if stderr := stdErrBuffer.String(); stderr != "" {
+		output += "\n" + stderr
 	}
-	return result, nil
+	return strings.TrimSpace(output), nil
 }
 
-func execute(ctx context.Context, cmd, input, dir string, envList []string) (string, error) {
+// runShell runs the command with the shell interpreter. Only the variables in envList are visible to the command.
+func runShell(
+	ctx context.Context, cmd string, stdin io.Reader, stdout, stderr io.Writer, dir string, envList []string,
+) error {
 	if ctx == nil {
 		ctx = context.Background()
 	}
@@ -422,44 +456,27 @@ func execute(ctx context.Context, cmd, input, dir string, envList []string) (str
 	reader := strings.NewReader(strings.TrimSpace(cmd))
 	prog, err := parser.Parse(reader, "")
 	if err != nil {
-		return "", fmt.Errorf("unable to parse command - %w", err)
-	}
-
-	if envList == nil {
-		envList = make([]string, 0)
+		return fmt.Errorf("unable to parse command - %w", err)
 	}
-	envList = append(os.Environ(), envList...)
-
-	stdInBuffer := strings.NewReader(input)
-	stdOutBuffer := &strings.Builder{}
-	stdErrBuffer := &strings.Builder{}
 
 	runner, err := interp.New(
 		interp.Dir(dir),
 		interp.Env(expand.ListEnviron(envList...)),
-		interp.StdIO(
-			stdInBuffer,
-			stdOutBuffer,
-			stdErrBuffer,
-		),
+		interp.StdIO(stdin, stdout, stderr),
 	)
 	if err != nil {
-		return "", fmt.Errorf("unable to create runner - %w", err)
+		return fmt.Errorf("unable to create runner - %w", err)
 	}
 
 	err = runner.Run(ctx, prog)
 	if err != nil {
 		var exitStatus interp.ExitStatus
 		if errors.As(err, &exitStatus) {
-			return stdErrBuffer.String(), fmt.Errorf("command exited with non-zero status %w", exitStatus)
+			return fmt.Errorf("command exited with non-zero status %w", exitStatus)
 		}
-		return stdErrBuffer.String(), fmt.Errorf("encountered an error executing command - %w", err)
-	}
-	output := stdOutBuffer.String()
-	if stderr := stdErrBuffer.String(); stderr != "" {
-		output += "\n" + stderr
+		return fmt.Errorf("encountered an error executing command - %w", err)
 	}
-	return strings.TrimSpace(output), nil
+	return nil
 }
 
 func expandEnv(env map[string]string) map[string]string {
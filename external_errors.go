@@ -0,0 +1,108 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+
+	"mvdan.cc/sh/v3/interp"
+)
+
+// Vault errors that an ErrorRule can map a failed command to
+const (
+	ErrorClassNotFound    = "not_found"
+	ErrorClassNoAccess    = "no_access"
+	ErrorClassInvalidKey  = "invalid_key"
+	ErrorClassUnavailable = "unavailable"
+	// ErrorClassSessionExpired makes the provider run the unlock command again and rerun the failed command
+	ErrorClassSessionExpired = "session_expired"
+)
+
+var errorClasses = map[string]error{
+	ErrorClassNotFound:       ErrSecretNotFound,
+	ErrorClassNoAccess:       ErrNoAccess,
+	ErrorClassInvalidKey:     ErrInvalidKey,
+	ErrorClassUnavailable:    ErrProviderUnavailable,
+	ErrorClassSessionExpired: ErrSessionExpired,
+}
+
+// ErrorRule maps a failed command to a vault error, so that callers can check the cause with errors.Is. A rule
+// matches when the exit code and stderr pattern both match; conditions that are not set match any failure.
+type ErrorRule struct {
+	// ExitCode of the command. Zero matches any exit code.
+	ExitCode int `json:"exit_code,omitempty"`
+	// Stderr is a regular expression matched against the command's stderr output
+	Stderr string `json:"stderr,omitempty"`
+	// Error is the class of vault error to return
+	// Must be one of: "not_found", "no_access", "invalid_key", "unavailable", "session_expired"
+	Error string `json:"error"`
+
+	// stderr is the compiled Stderr pattern, set when the provider is created
+	stderr *regexp.Regexp
+}
+
+func (r ErrorRule) validate() error {
+	if _, ok := errorClasses[r.Error]; !ok {
+		return fmt.Errorf("%w: invalid error rule class: %s", ErrInvalidConfig, r.Error)
+	}
+	if r.ExitCode < 0 || r.ExitCode > 255 {
+		return fmt.Errorf("%w: invalid error rule exit code: %d", ErrInvalidConfig, r.ExitCode)
+	}
+	if _, err := regexp.Compile(r.Stderr); err != nil {
+		return fmt.Errorf("%w: invalid error rule stderr pattern: %w", ErrInvalidConfig, err)
+	}
+	return nil
+}
+
+func (r ErrorRule) matches(exitCode int, stderr string) bool {
+	if r.ExitCode != 0 && r.ExitCode != exitCode {
+		return false
+	}
+	return r.stderr == nil || r.stderr.MatchString(stderr)
+}
+
+// classifyCommandError returns the vault error for a failed command, or nil if no rule matches. A command that is
+// stopped by the timeout is reported as unavailable.
+func classifyCommandError(ctx context.Context, rules []ErrorRule, runErr error, stderr string) error {
+	exitCode := -1
+	var exitStatus interp.ExitStatus
+	if errors.As(runErr, &exitStatus) {
+		exitCode = int(exitStatus)
+	}
+
+	for _, rule := range rules {
+		if rule.matches(exitCode, stderr) {
+			return errorClasses[rule.Error]
+		}
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return ErrProviderUnavailable
+	}
+	return nil
+}
+
+func (c *CommandConfig) validateErrorRules() error {
+	for _, rule := range c.Errors {
+		if err := rule.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compileErrorRules validates the error rules and compiles their stderr patterns. The rules are copied first so that
+// the caller's configuration is left untouched.
+func (c *CommandConfig) compileErrorRules() error {
+	if err := c.validateErrorRules(); err != nil {
+		return err
+	}
+	c.Errors = slices.Clone(c.Errors)
+	for i, rule := range c.Errors {
+		if rule.Stderr != "" {
+			c.Errors[i].stderr = regexp.MustCompile(rule.Stderr)
+		}
+	}
+	return nil
+}
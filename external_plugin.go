@@ -0,0 +1,162 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/flowexec/vault/plugin"
+)
+
+// defaultPluginTimeout bounds plugin calls when no timeout is configured
+const defaultPluginTimeout = 30 * time.Second
+
+// PluginConfig runs an external provider as a long-lived plugin process that speaks the JSON-RPC protocol
+// implemented by the plugin package, instead of running a command template for each operation.
+type PluginConfig struct {
+	// Command is the plugin executable
+	Command string `json:"cmd"`
+	// Args passed to the plugin
+	Args []string `json:"args,omitempty"`
+}
+
+func (c *PluginConfig) Validate() error {
+	if c.Command == "" {
+		return fmt.Errorf("%w: command is required for external vault plugin", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// startPlugin starts the configured plugin process with the provider environment
+func (v *ExternalVaultProvider) startPlugin() error {
+	cmd := exec.Command(v.cfg.Plugin.Command, v.cfg.Plugin.Args...) //nolint:gosec // the plugin is configured
+	cmd.Dir = v.cfg.WorkingDir
+	cmd.Env = append(os.Environ(), v.environmentToSlice()...)
+
+	ctx, cancel := v.pluginContext(context.Background())
+	defer cancel()
+	client, err := plugin.Start(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("%w: failed to start plugin %s: %w", ErrProviderUnavailable, v.cfg.Plugin.Command, err)
+	}
+	v.plugin = client
+	return nil
+}
+
+func (v *ExternalVaultProvider) pluginContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := defaultPluginTimeout
+	if v.cfg.Timeout != "" {
+		if dur, err := time.ParseDuration(v.cfg.Timeout); err == nil {
+			timeout = dur
+		}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// callPlugin calls the plugin method, retrying it according to the retry policy
+func (v *ExternalVaultProvider) callPlugin(ctx context.Context, method string, params, result interface{}) error {
+	if !v.plugin.Capabilities().Supports(method) {
+		return fmt.Errorf("%w: plugin does not support %s", ErrNotSupported, method)
+	}
+
+	_, err := v.withRetry(ctx, func(ctx context.Context) (string, error) {
+		ctx, cancel := v.pluginContext(ctx)
+		defer cancel()
+		return "", pluginError(v.plugin.Call(ctx, method, params, result))
+	})
+	return err
+}
+
+// pluginError maps a plugin error to the matching vault error
+func pluginError(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, plugin.ErrNotFound):
+		return fmt.Errorf("%w: %w", ErrSecretNotFound, err)
+	case errors.Is(err, plugin.ErrNoAccess):
+		return fmt.Errorf("%w: %w", ErrNoAccess, err)
+	case errors.Is(err, plugin.ErrInvalidKey):
+		return fmt.Errorf("%w: %w", ErrInvalidKey, err)
+	case errors.Is(err, plugin.ErrMethodNotFound):
+		return fmt.Errorf("%w: %w", ErrNotSupported, err)
+	case errors.Is(err, plugin.ErrUnavailable), errors.Is(err, plugin.ErrClosed),
+		errors.Is(err, context.DeadlineExceeded):
+		return fmt.Errorf("%w: %w", ErrProviderUnavailable, err)
+	default:
+		return err
+	}
+}
+
+func (v *ExternalVaultProvider) pluginGetSecret(ctx context.Context, key string) (Secret, error) {
+	if err := ValidateSecretKey(key); err != nil {
+		return nil, err
+	}
+	var result plugin.GetResult
+	if err := v.callPlugin(ctx, plugin.MethodGet, plugin.KeyParams{Key: key}, &result); err != nil {
+		return nil, fmt.Errorf("failed to get secret: %w", err)
+	}
+	return NewSecretValue(result.Value), nil
+}
+
+func (v *ExternalVaultProvider) pluginSetSecret(ctx context.Context, key string, value Secret) error {
+	if err := ValidateSecretKey(key); err != nil {
+		return err
+	}
+	params := plugin.SetParams{Key: key, Value: value.Bytes()}
+	if err := v.callPlugin(ctx, plugin.MethodSet, params, nil); err != nil {
+		return fmt.Errorf("failed to set secret: %w", err)
+	}
+	return nil
+}
+
+func (v *ExternalVaultProvider) pluginDeleteSecret(ctx context.Context, key string) error {
+	if err := ValidateSecretKey(key); err != nil {
+		return err
+	}
+	if err := v.callPlugin(ctx, plugin.MethodDelete, plugin.KeyParams{Key: key}, nil); err != nil {
+		return fmt.Errorf("failed to delete secret: %w", err)
+	}
+	return nil
+}
+
+func (v *ExternalVaultProvider) pluginListSecrets(ctx context.Context) ([]string, error) {
+	var result plugin.ListResult
+	if err := v.callPlugin(ctx, plugin.MethodList, struct{}{}, &result); err != nil {
+		return nil, fmt.Errorf("failed to list secrets: %w", err)
+	}
+	if result.Keys == nil {
+		return []string{}, nil
+	}
+	return result.Keys, nil
+}
+
+func (v *ExternalVaultProvider) pluginHasSecret(ctx context.Context, key string) (bool, error) {
+	if err := ValidateSecretKey(key); err != nil {
+		return false, err
+	}
+	if !v.plugin.Capabilities().Supports(plugin.MethodHas) {
+		_, err := v.pluginGetSecret(ctx, key)
+		if errors.Is(err, ErrSecretNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	var result plugin.HasResult
+	if err := v.callPlugin(ctx, plugin.MethodHas, plugin.KeyParams{Key: key}, &result); err != nil {
+		return false, err
+	}
+	return result.Exists, nil
+}
+
+func (v *ExternalVaultProvider) pluginMetadata() Metadata {
+	var result plugin.MetadataResult
+	if err := v.callPlugin(context.Background(), plugin.MethodMetadata, struct{}{}, &result); err != nil {
+		return Metadata{}
+	}
+	return Metadata{RawData: result.Data}
+}
@@ -0,0 +1,241 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryBackoff    = time.Second
+	defaultRetryMaxBackoff = 30 * time.Second
+	defaultCircuitCooldown = 30 * time.Second
+)
+
+// RetryConfig controls how failed external commands are retried
+type RetryConfig struct {
+	// MaxAttempts is the number of times a command is run, including the first attempt
+	MaxAttempts int `json:"max_attempts"`
+	// Backoff duration string to wait before the first retry. The wait doubles for each later retry.
+	Backoff string `json:"backoff,omitempty"`
+	// MaxBackoff duration string that caps the wait between retries
+	MaxBackoff string `json:"max_backoff,omitempty"`
+	// RetryOn lists the error classes that are retried. Defaults to "unavailable".
+	RetryOn []string `json:"retry_on,omitempty"`
+}
+
+func (c *RetryConfig) validate() error {
+	if c.MaxAttempts < 1 {
+		return fmt.Errorf("%w: retry max attempts must be at least 1", ErrInvalidConfig)
+	}
+	for _, class := range c.RetryOn {
+		if _, ok := errorClasses[class]; !ok {
+			return fmt.Errorf("%w: invalid retry error class: %s", ErrInvalidConfig, class)
+		}
+	}
+	_, err := newRetryPolicy(c)
+	return err
+}
+
+// CircuitBreakerConfig stops an external provider from running commands after repeated failures, so that callers
+// fail fast while the backing service is down
+type CircuitBreakerConfig struct {
+	// Threshold is the number of consecutive failed operations that opens the circuit
+	Threshold int `json:"threshold"`
+	// Cooldown duration string that the circuit stays open for before an operation may try the provider again
+	Cooldown string `json:"cooldown,omitempty"`
+}
+
+func (c *CircuitBreakerConfig) validate() error {
+	if c.Threshold < 1 {
+		return fmt.Errorf("%w: circuit breaker threshold must be at least 1", ErrInvalidConfig)
+	}
+	_, err := newCircuitBreaker(c)
+	return err
+}
+
+// CircuitOpenError is returned without running a command while the circuit breaker of an external provider is open
+type CircuitOpenError struct {
+	// Failures is the number of consecutive failures that opened the circuit
+	Failures int
+	// RetryAt is when an operation may next try the provider
+	RetryAt time.Time
+	// Err is the last failure
+	Err error
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s after %d failures, retry after %s: %v",
+		ErrCircuitOpen, e.Failures, e.RetryAt.Format(time.RFC3339), e.Err)
+}
+
+func (e *CircuitOpenError) Unwrap() []error {
+	return []error{ErrCircuitOpen, e.Err}
+}
+
+// retryPolicy is the parsed form of a RetryConfig. The zero value runs commands once.
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	retryOn    []error
+}
+
+func newRetryPolicy(cfg *RetryConfig) (retryPolicy, error) {
+	if cfg == nil {
+		return retryPolicy{attempts: 1}, nil
+	}
+
+	p := retryPolicy{
+		attempts:   max(cfg.MaxAttempts, 1),
+		backoff:    defaultRetryBackoff,
+		maxBackoff: defaultRetryMaxBackoff,
+	}
+	var err error
+	if cfg.Backoff != "" {
+		if p.backoff, err = time.ParseDuration(cfg.Backoff); err != nil {
+			return retryPolicy{}, fmt.Errorf("%w: invalid retry backoff: %w", ErrInvalidConfig, err)
+		}
+	}
+	if cfg.MaxBackoff != "" {
+		if p.maxBackoff, err = time.ParseDuration(cfg.MaxBackoff); err != nil {
+			return retryPolicy{}, fmt.Errorf("%w: invalid retry max backoff: %w", ErrInvalidConfig, err)
+		}
+	}
+
+	classes := cfg.RetryOn
+	if len(classes) == 0 {
+		classes = []string{ErrorClassUnavailable}
+	}
+	for _, class := range classes {
+		if target, ok := errorClasses[class]; ok {
+			p.retryOn = append(p.retryOn, target)
+		}
+	}
+	return p, nil
+}
+
+func (p retryPolicy) retryable(err error) bool {
+	return slices.ContainsFunc(p.retryOn, func(target error) bool {
+		return errors.Is(err, target)
+	})
+}
+
+// wait returns the backoff before the given retry, starting from 1
+func (p retryPolicy) wait(retry int) time.Duration {
+	wait := p.backoff
+	for i := 1; i < retry && wait < p.maxBackoff; i++ {
+		wait *= 2
+	}
+	return min(wait, p.maxBackoff)
+}
+
+// circuitBreaker counts consecutive failed operations. Once the threshold is reached the circuit opens and
+// operations fail fast until the cooldown passes, after which a single trial operation decides whether it closes.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+	trial     bool
+	lastErr   error
+}
+
+func newCircuitBreaker(cfg *CircuitBreakerConfig) (*circuitBreaker, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+
+	cooldown := defaultCircuitCooldown
+	if cfg.Cooldown != "" {
+		var err error
+		if cooldown, err = time.ParseDuration(cfg.Cooldown); err != nil {
+			return nil, fmt.Errorf("%w: invalid circuit breaker cooldown: %w", ErrInvalidConfig, err)
+		}
+	}
+	return &circuitBreaker{threshold: max(cfg.Threshold, 1), cooldown: cooldown}, nil
+}
+
+// allow returns a CircuitOpenError if the operation must not run
+func (b *circuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures < b.threshold {
+		return nil
+	}
+	if b.trial || time.Now().Before(b.openUntil) {
+		return &CircuitOpenError{Failures: b.failures, RetryAt: b.openUntil, Err: b.lastErr}
+	}
+	b.trial = true
+	return nil
+}
+
+// record records the outcome of an operation. Operations the caller gave up on say nothing about the provider.
+func (b *circuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.trial = false
+	if ctx.Err() != nil {
+		return
+	}
+	if !isProviderFailure(err) {
+		b.failures, b.lastErr = 0, nil
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// isProviderFailure reports whether the error means the provider is unhealthy. Errors about the secret or the
+// request itself show that the provider is responding.
+func isProviderFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	for _, target := range []error{ErrSecretNotFound, ErrNoAccess, ErrInvalidKey, ErrNotSupported, ErrSessionExpired} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// withRetry runs the operation, retrying failures that the retry policy allows, and records the outcome with the
+// circuit breaker
+func (v *ExternalVaultProvider) withRetry(
+	ctx context.Context, op func(context.Context) (string, error),
+) (string, error) {
+	if v.breaker != nil {
+		if err := v.breaker.allow(); err != nil {
+			return "", err
+		}
+	}
+
+	output, err := op(ctx)
+retries:
+	for retry := 1; retry < v.retry.attempts && err != nil && v.retry.retryable(err); retry++ {
+		timer := time.NewTimer(v.retry.wait(retry))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			err = fmt.Errorf("%w (retry stopped: %w)", err, ctx.Err())
+			break retries
+		case <-timer.C:
+		}
+		output, err = op(ctx)
+	}
+
+	if v.breaker != nil {
+		v.breaker.record(ctx, err)
+	}
+	return output, err
+}
@@ -0,0 +1,118 @@
+package vault
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// externalSession holds the output of the unlock command, such as a session key, for the commands that run after it
+type externalSession struct {
+	token    string
+	unlocked bool
+}
+
+func (c *ExternalConfig) validateSession() error {
+	if c.Lock.CommandTemplate != "" && c.Unlock.CommandTemplate == "" {
+		return fmt.Errorf("%w: lock command requires an unlock command", ErrInvalidConfig)
+	}
+	if c.SessionEnv != "" && c.Unlock.CommandTemplate == "" {
+		return fmt.Errorf("%w: session env requires an unlock command", ErrInvalidConfig)
+	}
+	return nil
+}
+
+// currentSession returns the session token and whether the provider is unlocked
+func (v *ExternalVaultProvider) currentSession() (string, bool) {
+	v.sessionMu.RLock()
+	defer v.sessionMu.RUnlock()
+	return v.session.token, v.session.unlocked
+}
+
+// sessionEnv returns the environment variable that passes the session token to commands, if one is configured
+func (v *ExternalVaultProvider) sessionEnv() []string {
+	token, unlocked := v.currentSession()
+	if v.cfg.SessionEnv == "" || !unlocked {
+		return nil
+	}
+	return []string{v.cfg.SessionEnv + "=" + token}
+}
+
+// withSession runs the operation once the provider is unlocked. If the operation fails because the session expired,
+// the provider is unlocked again and the operation is rerun once.
+func (v *ExternalVaultProvider) withSession(
+	ctx context.Context, op func(context.Context) (string, error),
+) (string, error) {
+	if v.cfg.Unlock.CommandTemplate == "" {
+		return op(ctx)
+	}
+
+	token, unlocked := v.currentSession()
+	if !unlocked {
+		if err := v.unlock(ctx, "", false); err != nil {
+			return "", err
+		}
+		token, _ = v.currentSession()
+	}
+
+	output, err := op(ctx)
+	if !errors.Is(err, ErrSessionExpired) {
+		return output, err
+	}
+	if err := v.unlock(ctx, token, true); err != nil {
+		return "", err
+	}
+	return op(ctx)
+}
+
+// unlock runs the unlock command and records its output as the session. When refreshing an expired session, a
+// session other than the stale one is kept, since another operation has already unlocked the provider again.
+func (v *ExternalVaultProvider) unlock(ctx context.Context, stale string, refresh bool) error {
+	v.unlockMu.Lock()
+	defer v.unlockMu.Unlock()
+
+	if token, unlocked := v.currentSession(); unlocked && (!refresh || token != stale) {
+		return nil
+	}
+	v.setSession(externalSession{})
+
+	output, err := v.runCommandConfig(ctx, "unlock", v.cfg.Unlock, "")
+	if err != nil {
+		return fmt.Errorf("failed to unlock: %w", err)
+	}
+	token := strings.TrimSpace(output)
+	if v.cfg.Unlock.OutputTemplate != "" {
+		if token, err = v.renderOutputTemplate(v.cfg.Unlock.OutputTemplate, output); err != nil {
+			return fmt.Errorf("failed to parse unlock output: %w", err)
+		}
+	}
+
+	v.setSession(externalSession{token: token, unlocked: true})
+	return nil
+}
+
+// lock runs the lock command if the provider is unlocked and forgets the session
+func (v *ExternalVaultProvider) lock(ctx context.Context) error {
+	v.unlockMu.Lock()
+	defer v.unlockMu.Unlock()
+
+	if _, unlocked := v.currentSession(); !unlocked {
+		return nil
+	}
+	var err error
+	if v.cfg.Lock.CommandTemplate != "" {
+		_, err = v.runCommandConfig(ctx, "lock", v.cfg.Lock, "")
+	}
+	v.setSession(externalSession{})
+	if err != nil {
+		return fmt.Errorf("failed to lock: %w", err)
+	}
+	return nil
+}
+
+func (v *ExternalVaultProvider) setSession(s externalSession) {
+	v.sessionMu.Lock()
+	defer v.sessionMu.Unlock()
+	v.session = s
+}
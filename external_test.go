@@ -2,13 +2,32 @@ package vault_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
+
+	"mvdan.cc/sh/v3/interp"
 
 	"github.com/flowexec/vault"
+	"github.com/flowexec/vault/plugin"
 )
 
+// testPluginEnv makes the test binary serve the in-memory plugin instead of running tests
+const testPluginEnv = "VAULT_TEST_PLUGIN"
+
+func runTestPlugin() {
+	if err := plugin.Serve(plugin.NewMemory()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
 func TestNewExternalVaultProvider(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -214,6 +233,170 @@ func TestExternalVaultProvider_SetSecret(t *testing.T) {
 	}
 }
 
+func TestExternalVaultProvider_SetSecretValueDelivery(t *testing.T) {
+	tests := []struct {
+		name      string
+		set       vault.CommandConfig
+		wantCmd   string
+		wantInput string
+		wantEnv   string
+	}{
+		{
+			name:      "stdin",
+			set:       vault.CommandConfig{CommandTemplate: "pass insert -e {{key}}", ValueVia: vault.ValueViaStdin},
+			wantCmd:   "pass insert -e test-key",
+			wantInput: "s3cret",
+		},
+		{
+			name: "stdin with input template",
+			set: vault.CommandConfig{
+				CommandTemplate: "op item create --title {{key}} -",
+				InputTemplate:   `{"password":{{toJSON(value)}}}`,
+				ValueVia:        vault.ValueViaStdin,
+			},
+			wantCmd:   "op item create --title test-key -",
+			wantInput: `{"password":"s3cret"}`,
+		},
+		{
+			name: "env",
+			set: vault.CommandConfig{
+				CommandTemplate: `store {{key}} "$VAULT_SECRET_VALUE"`,
+				ValueVia:        vault.ValueViaEnv,
+			},
+			wantCmd: `store test-key "${VAULT_SECRET_VALUE}"`,
+			wantEnv: "VAULT_SECRET_VALUE=s3cret",
+		},
+		{
+			name: "env with custom name",
+			set: vault.CommandConfig{
+				CommandTemplate: "store {{key}}",
+				ValueVia:        vault.ValueViaEnv,
+				ValueEnv:        "SECRET",
+			},
+			wantCmd: "store test-key",
+			wantEnv: "SECRET=s3cret",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider, err := vault.NewExternalVaultProvider(&vault.Config{
+				ID:       "test-vault",
+				Type:     vault.ProviderTypeExternal,
+				External: &vault.ExternalConfig{Set: tt.set},
+			})
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+
+			var gotCmd, gotInput string
+			var gotEnv []string
+			provider.SetExecutionFunc(func(_ context.Context, cmd, input, _ string, envList []string) (string, error) {
+				gotCmd, gotInput, gotEnv = cmd, input, envList
+				return "", nil
+			})
+
+			if err := provider.SetSecret("test-key", vault.NewSecretValue([]byte("s3cret"))); err != nil {
+				t.Fatalf("SetSecret() error = %v", err)
+			}
+			if gotCmd != tt.wantCmd {
+				t.Errorf("command = %q, want %q", gotCmd, tt.wantCmd)
+			}
+			if gotInput != tt.wantInput {
+				t.Errorf("input = %q, want %q", gotInput, tt.wantInput)
+			}
+			if tt.wantEnv != "" && !slices.Contains(gotEnv, tt.wantEnv) {
+				t.Errorf("environment %v does not contain %q", gotEnv, tt.wantEnv)
+			}
+			if strings.Contains(gotCmd, "s3cret") {
+				t.Errorf("command %q contains the secret value", gotCmd)
+			}
+		})
+	}
+}
+
+func TestExternalVaultProvider_SetSecretViaFIFO(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("named pipes are not available on windows")
+	}
+
+	out := filepath.Join(t.TempDir(), "out.txt")
+	provider, err := vault.NewExternalVaultProvider(&vault.Config{
+		ID:   "test-vault",
+		Type: vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{
+			Set: vault.CommandConfig{CommandTemplate: "cat {{valueFile}} > " + out, ValueVia: vault.ValueViaFIFO},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	if err := provider.SetSecret("test-key", vault.NewSecretValue([]byte("s3cret"))); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatalf("Failed to read command output: %v", err)
+	}
+	if string(data) != "s3cret" {
+		t.Errorf("command read %q from the value pipe, want %q", data, "s3cret")
+	}
+
+	// a command that never reads the value must not block the provider
+	provider, _ = vault.NewExternalVaultProvider(&vault.Config{
+		ID:   "test-vault",
+		Type: vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{
+			Set: vault.CommandConfig{CommandTemplate: "true", ValueVia: vault.ValueViaFIFO},
+		},
+	})
+	if err := provider.SetSecret("test-key", vault.NewSecretValue([]byte("s3cret"))); err != nil {
+		t.Fatalf("SetSecret() error = %v", err)
+	}
+}
+
+func TestExternalConfigValueDelivery(t *testing.T) {
+	cfg := &vault.ExternalConfig{
+		Get: vault.CommandConfig{CommandTemplate: "get {{key}}"},
+		Set: vault.CommandConfig{CommandTemplate: "put {{key}} --value {{ value }}"},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected a value interpolated into the set command to be allowed via args, got: %v", err)
+	}
+	for _, via := range []string{"", vault.ValueViaArgs} {
+		cfg.Set.ValueVia = via
+		if warnings := cfg.Warnings(); len(warnings) != 1 {
+			t.Errorf("Expected a warning for a value interpolated into the set command via %q, got %v", via, warnings)
+		}
+	}
+
+	for _, via := range []string{vault.ValueViaStdin, vault.ValueViaEnv, vault.ValueViaFIFO} {
+		cfg.Set.ValueVia = via
+		if err := cfg.Validate(); !errors.Is(err, vault.ErrInvalidConfig) {
+			t.Errorf("Expected ErrInvalidConfig for a value interpolated into the set command via %s, got: %v", via, err)
+		}
+	}
+	cfg.Set.CommandTemplate = "put {{key}} --password {{password}}"
+	_, err := vault.NewExternalVaultProvider(&vault.Config{ID: "test-vault", External: cfg})
+	if !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected NewExternalVaultProvider to reject the set command, got: %v", err)
+	}
+
+	cfg.Set = vault.CommandConfig{CommandTemplate: "put {{key}}", ValueVia: vault.ValueViaStdin}
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+	if warnings := cfg.Warnings(); len(warnings) != 0 {
+		t.Errorf("Expected no warnings, got %v", warnings)
+	}
+
+	cfg.Set.ValueVia = "clipboard"
+	if err := cfg.Validate(); !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for an unknown value delivery, got: %v", err)
+	}
+}
+
 func TestExternalVaultProvider_ListSecrets(t *testing.T) {
 	config := &vault.Config{
 		ID:   "test-vault",
@@ -350,6 +533,274 @@ func TestExternalVaultProvider_HasSecret(t *testing.T) {
 	}
 }
 
+func TestExternalVaultProvider_ErrorRules(t *testing.T) {
+	get := vault.CommandConfig{
+		CommandTemplate: "pass show {{key}}",
+		Errors: []vault.ErrorRule{
+			{Stderr: `is not in the password store`, Error: vault.ErrorClassNotFound},
+			{ExitCode: 2, Stderr: `(?i)decryption failed`, Error: vault.ErrorClassNoAccess},
+			{ExitCode: 3, Error: vault.ErrorClassUnavailable},
+		},
+	}
+	provider, err := vault.NewExternalVaultProvider(&vault.Config{
+		ID:       "test-vault",
+		Type:     vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{Get: get},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		stderr  string
+		exit    uint8
+		wantErr error
+	}{
+		{"not found", "Error: db is not in the password store.", 1, vault.ErrSecretNotFound},
+		{"no access", "gpg: decryption failed: No secret key", 2, vault.ErrNoAccess},
+		{"exit code must match", "gpg: decryption failed: No secret key", 1, nil},
+		{"unavailable", "", 3, vault.ErrProviderUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider.SetExecutionFunc(func(context.Context, string, string, string, []string) (string, error) {
+				return tt.stderr, fmt.Errorf("command exited with non-zero status %w", interp.ExitStatus(tt.exit))
+			})
+
+			_, err := provider.GetSecret("db")
+			if err == nil {
+				t.Fatal("GetSecret() expected an error")
+			}
+			for _, class := range []error{vault.ErrSecretNotFound, vault.ErrNoAccess, vault.ErrProviderUnavailable} {
+				if errors.Is(err, class) != (class == tt.wantErr) {
+					t.Errorf("GetSecret() error = %v, want %v", err, tt.wantErr)
+				}
+			}
+
+			exists, err := provider.HasSecret("db")
+			if tt.wantErr == vault.ErrSecretNotFound {
+				if exists || err != nil {
+					t.Errorf("HasSecret() = %v, %v, want false, nil", exists, err)
+				}
+			} else if err == nil {
+				t.Error("HasSecret() expected an error for a failure other than not found")
+			}
+		})
+	}
+
+	// a command stopped by the timeout is unavailable
+	provider, _ = vault.NewExternalVaultProvider(&vault.Config{
+		ID:   "test-vault",
+		Type: vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{
+			Get:     vault.CommandConfig{CommandTemplate: "sleep 5"},
+			Timeout: "20ms",
+		},
+	})
+	if _, err := provider.GetSecret("db"); !errors.Is(err, vault.ErrProviderUnavailable) {
+		t.Errorf("Expected ErrProviderUnavailable for a command that timed out, got: %v", err)
+	}
+
+	cfg := &vault.ExternalConfig{
+		Get: vault.CommandConfig{CommandTemplate: "get", Errors: []vault.ErrorRule{{Error: "gone"}}},
+		Set: vault.CommandConfig{CommandTemplate: "set"},
+	}
+	if err := cfg.Validate(); !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for an unknown error class, got: %v", err)
+	}
+
+	cfg.Get.Errors = []vault.ErrorRule{{Stderr: "(unclosed", Error: vault.ErrorClassNotFound}}
+	_, err = vault.NewExternalVaultProvider(&vault.Config{ID: "test-vault", External: cfg})
+	if !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected NewExternalVaultProvider to reject an invalid stderr pattern, got: %v", err)
+	}
+}
+
+func TestExternalVaultProvider_RetryAndCircuitBreaker(t *testing.T) {
+	get := vault.CommandConfig{
+		CommandTemplate: "bw get password {{key}}",
+		Errors: []vault.ErrorRule{
+			{Stderr: "Not found", Error: vault.ErrorClassNotFound},
+			{Stderr: "(?i)rate limit", Error: vault.ErrorClassUnavailable},
+		},
+	}
+	provider, err := vault.NewExternalVaultProvider(&vault.Config{
+		ID:   "test-vault",
+		Type: vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{
+			Get:            get,
+			Set:            vault.CommandConfig{CommandTemplate: "bw create item"},
+			Retry:          &vault.RetryConfig{MaxAttempts: 3, Backoff: "1ms"},
+			CircuitBreaker: &vault.CircuitBreakerConfig{Threshold: 2, Cooldown: "50ms"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	var calls int
+	respond := func(failures int, stderr string) {
+		calls = 0
+		provider.SetExecutionFunc(func(context.Context, string, string, string, []string) (string, error) {
+			calls++
+			if calls <= failures {
+				return stderr, fmt.Errorf("command exited with non-zero status %w", interp.ExitStatus(1))
+			}
+			return "s3cr3t", nil
+		})
+	}
+
+	// transient failures are retried
+	respond(2, "Error: rate limit exceeded")
+	secret, err := provider.GetSecret("db")
+	if err != nil {
+		t.Fatalf("Expected retries to succeed, got: %v", err)
+	}
+	if secret.PlainTextString() != "s3cr3t" || calls != 3 {
+		t.Errorf("Expected s3cr3t after 3 attempts, got %q after %d", secret.PlainTextString(), calls)
+	}
+
+	// other error classes are not retried and do not count toward the circuit breaker
+	respond(5, "Not found.")
+	if _, err := provider.GetSecret("db"); !errors.Is(err, vault.ErrSecretNotFound) || calls != 1 {
+		t.Errorf("Expected ErrSecretNotFound after 1 attempt, got %v after %d", err, calls)
+	}
+
+	// the circuit opens after repeated failed operations
+	respond(100, "Error: rate limit exceeded")
+	for range 2 {
+		if _, err := provider.GetSecret("db"); !errors.Is(err, vault.ErrProviderUnavailable) {
+			t.Fatalf("Expected ErrProviderUnavailable, got: %v", err)
+		}
+	}
+	if calls != 6 {
+		t.Errorf("Expected 6 attempts before the circuit opened, got %d", calls)
+	}
+	_, err = provider.GetSecret("db")
+	var circuitErr *vault.CircuitOpenError
+	if !errors.As(err, &circuitErr) || !errors.Is(err, vault.ErrCircuitOpen) {
+		t.Fatalf("Expected CircuitOpenError, got: %v", err)
+	}
+	if !errors.Is(err, vault.ErrProviderUnavailable) || circuitErr.Failures != 2 {
+		t.Errorf("Expected the circuit error to report the last failure after 2 failures, got: %v", err)
+	}
+	if calls != 6 {
+		t.Errorf("Expected no command to run while the circuit is open, got %d attempts", calls)
+	}
+
+	// a successful trial operation after the cooldown closes the circuit
+	time.Sleep(60 * time.Millisecond)
+	respond(0, "")
+	if _, err := provider.GetSecret("db"); err != nil {
+		t.Fatalf("Expected the trial operation to succeed, got: %v", err)
+	}
+	respond(1, "Error: rate limit exceeded")
+	if _, err := provider.GetSecret("db"); err != nil {
+		t.Errorf("Expected the circuit to be closed, got: %v", err)
+	}
+
+	cfg := &vault.ExternalConfig{
+		Get:   vault.CommandConfig{CommandTemplate: "get"},
+		Set:   vault.CommandConfig{CommandTemplate: "set"},
+		Retry: &vault.RetryConfig{MaxAttempts: 2, RetryOn: []string{"timeout"}},
+	}
+	if err := cfg.Validate(); !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for an unknown retry error class, got: %v", err)
+	}
+}
+
+func TestExternalVaultProvider_Session(t *testing.T) {
+	provider, err := vault.NewExternalVaultProvider(&vault.Config{
+		ID:   "test-vault",
+		Type: vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{
+			Get: vault.CommandConfig{
+				CommandTemplate: "bw get password {{key}}",
+				Errors:          []vault.ErrorRule{{Stderr: "Invalid session", Error: vault.ErrorClassSessionExpired}},
+			},
+			Set:        vault.CommandConfig{CommandTemplate: "bw edit item {{key}} --session {{session}}"},
+			Unlock:     vault.CommandConfig{CommandTemplate: "bw unlock --raw"},
+			Lock:       vault.CommandConfig{CommandTemplate: "bw lock"},
+			SessionEnv: "BW_SESSION",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+
+	var commands []string
+	unlocks := 0
+	expired := "token-1"
+	provider.SetExecutionFunc(func(_ context.Context, cmd, _, _ string, env []string) (string, error) {
+		commands = append(commands, cmd)
+		switch {
+		case cmd == "bw unlock --raw":
+			unlocks++
+			return fmt.Sprintf("token-%d", unlocks), nil
+		case strings.HasPrefix(cmd, "bw get"):
+			if slices.Contains(env, "BW_SESSION="+expired) {
+				return "Invalid session", fmt.Errorf("command exited with non-zero status %w", interp.ExitStatus(1))
+			}
+			if !slices.Contains(env, fmt.Sprintf("BW_SESSION=token-%d", unlocks)) {
+				return "You are not logged in", fmt.Errorf("command exited with non-zero status %w", interp.ExitStatus(1))
+			}
+			return "s3cr3t", nil
+		}
+		return "", nil
+	})
+
+	// the first session expires, so the provider unlocks again and reruns the command
+	secret, err := provider.GetSecret("db")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "s3cr3t" || unlocks != 2 {
+		t.Errorf("Expected s3cr3t after 2 unlocks, got %q after %d", secret.PlainTextString(), unlocks)
+	}
+
+	if err := provider.SetSecret("db", vault.NewSecretValue([]byte("new"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	if last := commands[len(commands)-1]; last != "bw edit item db --session token-2" || unlocks != 2 {
+		t.Errorf("Expected the set command to reuse the session, got %q after %d unlocks", last, unlocks)
+	}
+
+	// a session that still fails after unlocking again is reported
+	expired = "token-3"
+	unlocks = 2
+	provider.SetExecutionFunc(func(_ context.Context, cmd, _, _ string, _ []string) (string, error) {
+		commands = append(commands, cmd)
+		switch cmd {
+		case "bw unlock --raw":
+			unlocks++
+			return "token-3", nil
+		case "bw lock":
+			return "", nil
+		}
+		return "Invalid session", fmt.Errorf("command exited with non-zero status %w", interp.ExitStatus(1))
+	})
+	if _, err := provider.GetSecret("db"); !errors.Is(err, vault.ErrSessionExpired) || unlocks != 3 {
+		t.Errorf("Expected ErrSessionExpired after unlocking again once, got %v after %d unlocks", err, unlocks)
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("Failed to close provider: %v", err)
+	}
+	if last := commands[len(commands)-1]; last != "bw lock" {
+		t.Errorf("Expected Close to run the lock command, got %q", last)
+	}
+
+	cfg := &vault.ExternalConfig{
+		Get:  vault.CommandConfig{CommandTemplate: "get"},
+		Set:  vault.CommandConfig{CommandTemplate: "set"},
+		Lock: vault.CommandConfig{CommandTemplate: "lock"},
+	}
+	if err := cfg.Validate(); !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a lock command without an unlock command, got: %v", err)
+	}
+}
+
 func TestExternalVaultProvider_Metadata(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -430,3 +881,59 @@ func mockCommandContext(
 		return "mock", nil
 	}
 }
+
+func TestExternalVaultProvider_Plugin(t *testing.T) {
+	provider, err := vault.NewExternalVaultProvider(&vault.Config{
+		ID:   "plugin-vault",
+		Type: vault.ProviderTypeExternal,
+		External: &vault.ExternalConfig{
+			Plugin:      &vault.PluginConfig{Command: os.Args[0], Args: []string{"-test.run=^$"}},
+			Environment: map[string]string{testPluginEnv: "memory"},
+			Timeout:     "10s",
+		},
+	})
+	if err != nil {
+		t.Fatalf("Failed to start plugin: %v", err)
+	}
+	defer provider.Close()
+
+	if err := provider.SetSecret("api-key", vault.NewSecretValue([]byte("s3cr3t"))); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	secret, err := provider.GetSecret("api-key")
+	if err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if secret.PlainTextString() != "s3cr3t" {
+		t.Errorf("Expected s3cr3t, got %s", secret.PlainTextString())
+	}
+
+	keys, err := provider.ListSecrets()
+	if err != nil {
+		t.Fatalf("Failed to list secrets: %v", err)
+	}
+	if !slices.Equal(keys, []string{"api-key"}) {
+		t.Errorf("Expected [api-key], got %v", keys)
+	}
+
+	if err := provider.DeleteSecret("api-key"); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+	exists, err := provider.HasSecret("api-key")
+	if err != nil {
+		t.Fatalf("Failed to check secret: %v", err)
+	}
+	if exists {
+		t.Error("Expected deleted secret not to exist")
+	}
+	if _, err := provider.GetSecret("api-key"); !errors.Is(err, vault.ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound, got %v", err)
+	}
+
+	if err := provider.Close(); err != nil {
+		t.Fatalf("Failed to close provider: %v", err)
+	}
+	if _, err := provider.GetSecret("api-key"); !errors.Is(err, vault.ErrProviderUnavailable) {
+		t.Errorf("Expected ErrProviderUnavailable after close, got %v", err)
+	}
+}
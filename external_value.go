@@ -0,0 +1,145 @@
+package vault
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+// Ways of passing the secret value to the set command of an external provider
+const (
+	// ValueViaArgs interpolates the value into the command line with {{value}} or {{password}}. The value is
+	// visible to other processes, e.g. in `ps` output.
+	ValueViaArgs = "args"
+	// ValueViaStdin writes the value, or the rendered input template, to the command's standard input
+	ValueViaStdin = "stdin"
+	// ValueViaEnv sets the value in an environment variable of the command
+	ValueViaEnv = "env"
+	// ValueViaFIFO writes the value to a named pipe whose path is available to the command as {{valueFile}}
+	ValueViaFIFO = "fifo"
+
+	// DefaultValueEnv is the environment variable that holds the secret value when it is passed via env
+	DefaultValueEnv = "VAULT_SECRET_VALUE"
+)
+
+// valueTemplatePattern matches template expressions that reference the secret value
+var valueTemplatePattern = regexp.MustCompile(`\{\{[^}]*\b(value|password)\b[^}]*}}`)
+
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Warnings returns configuration issues that do not prevent the provider from being used but should be addressed,
+// such as a set command that exposes the secret value on the command line.
+func (c *ExternalConfig) Warnings() []string {
+	var warnings []string
+	viaArgs := c.Set.ValueVia == "" || c.Set.ValueVia == ValueViaArgs
+	if viaArgs && valueTemplatePattern.MatchString(c.Set.CommandTemplate) {
+		warnings = append(warnings, fmt.Sprintf(
+			"set command interpolates the secret value into its arguments, where it is visible to other processes; "+
+				"use value_via %q, %q or %q instead", ValueViaStdin, ValueViaEnv, ValueViaFIFO,
+		))
+	}
+	return warnings
+}
+
+func (c *CommandConfig) validateValueDelivery() error {
+	switch c.ValueVia {
+	case "", ValueViaArgs:
+		return nil
+	case ValueViaStdin, ValueViaFIFO:
+	case ValueViaEnv:
+		if c.ValueEnv != "" && !envNamePattern.MatchString(c.ValueEnv) {
+			return fmt.Errorf("%w: invalid value environment variable name: %s", ErrInvalidConfig, c.ValueEnv)
+		}
+	default:
+		return fmt.Errorf("%w: invalid value delivery: %s", ErrInvalidConfig, c.ValueVia)
+	}
+
+	// the value would end up on the command line after all
+	if valueTemplatePattern.MatchString(c.CommandTemplate) {
+		return fmt.Errorf(
+			"%w: set command must not reference {{value}} or {{password}} when the value is passed via %s",
+			ErrInvalidConfig, c.ValueVia,
+		)
+	}
+	return nil
+}
+
+func (c *CommandConfig) valueEnv() string {
+	if c.ValueEnv == "" {
+		return DefaultValueEnv
+	}
+	return c.ValueEnv
+}
+
+// setSecretCommand renders the set command for the configured value delivery and runs it. The value is delivered
+// again for each retry, since a named pipe can only be read once.
+func (v *ExternalVaultProvider) setSecretCommand(ctx context.Context, key, value string) (string, error) {
+	return v.withSession(ctx, func(ctx context.Context) (string, error) {
+		return v.withRetry(ctx, func(ctx context.Context) (string, error) {
+			return v.runSetSecretCommand(ctx, key, value)
+		})
+	})
+}
+
+func (v *ExternalVaultProvider) runSetSecretCommand(ctx context.Context, key, value string) (string, error) {
+	set := v.cfg.Set
+	data := map[string]interface{}{"key": key}
+
+	var input string
+	var env []string
+	switch set.ValueVia {
+	case "", ValueViaArgs:
+		data["value"], data["password"] = value, value
+	case ValueViaStdin:
+		input = value
+	case ValueViaEnv:
+		env = append(env, set.valueEnv()+"="+value)
+	case ValueViaFIFO:
+		path, cleanup, err := writeValueFIFO(value)
+		if err != nil {
+			return "", fmt.Errorf("failed to create value pipe: %w", err)
+		}
+		defer cleanup()
+		data["valueFile"] = path
+	}
+
+	cmd, err := v.renderTemplate("args", set.CommandTemplate, data, v.keepValueEnv())
+	if err != nil {
+		return "", fmt.Errorf("failed to render set cmd: %w", err)
+	}
+	if set.InputTemplate != "" {
+		input, err = v.renderTemplate("input", set.InputTemplate, map[string]interface{}{
+			"input":    key,
+			"key":      key,
+			"value":    value,
+			"password": value,
+		}, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to render input template: %w", err)
+		}
+	}
+
+	return v.runCommand(ctx, set.Errors, cmd, input, env...)
+}
+
+// keepValueEnv returns the environment variables that must be left for the shell to expand when rendering the set
+// command, so that a reference to the value variable is not replaced with the caller's environment
+func (v *ExternalVaultProvider) keepValueEnv() []string {
+	if v.cfg.Set.ValueVia != ValueViaEnv {
+		return nil
+	}
+	return []string{v.cfg.Set.valueEnv()}
+}
+
+// expandEnvExcept replaces environment variable references in s, leaving the named variables unexpanded
+func expandEnvExcept(s string, keep []string) string {
+	return os.Expand(s, func(name string) string {
+		for _, k := range keep {
+			if name == k {
+				return "${" + name + "}"
+			}
+		}
+		return os.Getenv(name)
+	})
+}
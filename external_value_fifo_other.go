@@ -0,0 +1,11 @@
+//go:build !unix
+
+package vault
+
+import (
+	"fmt"
+)
+
+func writeValueFIFO(string) (string, func(), error) {
+	return "", nil, fmt.Errorf("%w: named pipes are not available on this platform", ErrNotSupported)
+}
@@ -0,0 +1,67 @@
+//go:build unix
+
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// fifoOpenInterval is how often the value pipe is checked for a reader
+const fifoOpenInterval = 10 * time.Millisecond
+
+// writeValueFIFO creates a named pipe that only the current user can access and writes the value to it once the
+// command opens it. The returned cleanup function stops a pending write and removes the pipe; it must be called
+// after the command has exited.
+func writeValueFIFO(value string) (string, func(), error) {
+	dir, err := os.MkdirTemp("", "vault-value-")
+	if err != nil {
+		return "", nil, err
+	}
+	path := filepath.Join(dir, "value")
+	if err := syscall.Mkfifo(path, 0600); err != nil {
+		_ = os.RemoveAll(dir)
+		return "", nil, err
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// opening a pipe for writing without blocking fails until there is a reader
+		f, err := os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		for errors.Is(err, syscall.ENXIO) {
+			select {
+			case <-stop:
+				return
+			case <-time.After(fifoOpenInterval):
+			}
+			f, err = os.OpenFile(path, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+		}
+		if err != nil {
+			return
+		}
+
+		written := make(chan struct{})
+		go func() {
+			// a command that stops reading early must not leave the write blocked
+			select {
+			case <-stop:
+			case <-written:
+			}
+			_ = f.Close()
+		}()
+		_, _ = f.WriteString(value)
+		close(written)
+	}()
+
+	cleanup := func() {
+		close(stop)
+		<-done
+		_ = os.RemoveAll(dir)
+	}
+	return path, cleanup, nil
+}
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -162,6 +163,10 @@ func (v *KeyringVault) Metadata() Metadata {
 }
 
 func (v *KeyringVault) GetSecret(key string) (Secret, error) {
+	return v.GetSecretContext(context.Background(), key)
+}
+
+func (v *KeyringVault) GetSecretContext(ctx context.Context, key string) (Secret, error) {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -169,7 +174,9 @@ func (v *KeyringVault) GetSecret(key string) (Secret, error) {
 		return nil, err
 	}
 
-	data, err := keyring.Get(v.service, v.secretKey(key))
+	data, err := keyringCall(ctx, func() (string, error) {
+		return keyring.Get(v.service, v.secretKey(key))
+	})
 	if err != nil {
 		if errors.Is(err, keyring.ErrNotFound) {
 			return nil, ErrSecretNotFound
@@ -181,6 +188,10 @@ func (v *KeyringVault) GetSecret(key string) (Secret, error) {
 }
 
 func (v *KeyringVault) SetSecret(key string, secret Secret) error {
+	return v.SetSecretContext(context.Background(), key, secret)
+}
+
+func (v *KeyringVault) SetSecretContext(ctx context.Context, key string, secret Secret) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
@@ -188,10 +199,16 @@ func (v *KeyringVault) SetSecret(key string, secret Secret) error {
 		return err
 	}
 
-	if err := keyring.Set(v.service, v.secretKey(key), secret.PlainTextString()); err != nil {
+	_, err := keyringCall(ctx, func() (struct{}, error) {
+		return struct{}{}, keyring.Set(v.service, v.secretKey(key), secret.PlainTextString())
+	})
+	if err != nil {
 		return fmt.Errorf("failed to set secret in keyring: %w", err)
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := v.addSecretToList(key); err != nil {
 		return fmt.Errorf("failed to update secrets list: %w", err)
 	}
@@ -200,6 +217,10 @@ func (v *KeyringVault) SetSecret(key string, secret Secret) error {
 }
 
 func (v *KeyringVault) DeleteSecret(key string) error {
+	return v.DeleteSecretContext(context.Background(), key)
+}
+
+func (v *KeyringVault) DeleteSecretContext(ctx context.Context, key string) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
@@ -208,7 +229,9 @@ func (v *KeyringVault) DeleteSecret(key string) error {
 	}
 
 	// Check if secret exists first
-	_, err := keyring.Get(v.service, v.secretKey(key))
+	_, err := keyringCall(ctx, func() (string, error) {
+		return keyring.Get(v.service, v.secretKey(key))
+	})
 	if err != nil {
 		if errors.Is(err, keyring.ErrNotFound) {
 			return ErrSecretNotFound
@@ -216,10 +239,16 @@ func (v *KeyringVault) DeleteSecret(key string) error {
 		return fmt.Errorf("failed to check secret existence: %w", err)
 	}
 
-	if err := keyring.Delete(v.service, v.secretKey(key)); err != nil {
+	_, err = keyringCall(ctx, func() (struct{}, error) {
+		return struct{}{}, keyring.Delete(v.service, v.secretKey(key))
+	})
+	if err != nil {
 		return fmt.Errorf("failed to delete secret from keyring: %w", err)
 	}
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 	if err := v.removeSecretFromList(key); err != nil {
 		return fmt.Errorf("failed to update secrets list: %w", err)
 	}
@@ -228,10 +257,14 @@ func (v *KeyringVault) DeleteSecret(key string) error {
 }
 
 func (v *KeyringVault) ListSecrets() ([]string, error) {
+	return v.ListSecretsContext(context.Background())
+}
+
+func (v *KeyringVault) ListSecretsContext(ctx context.Context) ([]string, error) {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
-	secrets, err := v.loadSecretsList()
+	secrets, err := keyringCall(ctx, v.loadSecretsList)
 	if err != nil {
 		return nil, fmt.Errorf("failed to load secrets list: %w", err)
 	}
@@ -244,6 +277,10 @@ func (v *KeyringVault) ListSecrets() ([]string, error) {
 }
 
 func (v *KeyringVault) HasSecret(key string) (bool, error) {
+	return v.HasSecretContext(context.Background(), key)
+}
+
+func (v *KeyringVault) HasSecretContext(ctx context.Context, key string) (bool, error) {
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -251,7 +288,9 @@ func (v *KeyringVault) HasSecret(key string) (bool, error) {
 		return false, err
 	}
 
-	_, err := keyring.Get(v.service, v.secretKey(key))
+	_, err := keyringCall(ctx, func() (string, error) {
+		return keyring.Get(v.service, v.secretKey(key))
+	})
 	if err != nil {
 		if errors.Is(err, keyring.ErrNotFound) {
 			return false, nil
@@ -272,3 +311,30 @@ func (v *KeyringVault) Close() error {
 
 	return nil
 }
+
+// keyringCall runs a keyring operation on a separate goroutine so that the caller is released as soon as ctx is
+// done, even if the OS keyring backend is blocked (e.g. waiting on an unlock prompt). A write that the caller is
+// released from may still be applied once the backend unblocks.
+func keyringCall[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+
+	type result struct {
+		val T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		val, err := fn()
+		done <- result{val: val, err: err}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case r := <-done:
+		return r.val, r.err
+	}
+}
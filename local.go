@@ -9,9 +9,12 @@ import (
 )
 
 const (
-	vaultFileBase = "vault"
-	envSource     = "env"
-	fileSource    = "file"
+	vaultFileBase    = "vault"
+	envSource        = "env"
+	fileSource       = "file"
+	passphraseSource = "passphrase"
+	callbackSource   = "callback"
+	commandSource    = "command"
 )
 
 var (
@@ -58,6 +61,43 @@ func validateSecurePath(path string) error {
 	return nil
 }
 
+// readPassphrase reads a passphrase from a file if a path is provided, otherwise from the environment variable.
+// Trailing line breaks are removed from file contents; all other whitespace is significant.
+func readPassphrase(envVar, path string) (string, error) {
+	if path == "" {
+		return os.Getenv(envVar), nil
+	}
+
+	expandedPath, err := expandPath(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to expand passphrase file path %s: %w", path, err)
+	}
+
+	data, err := os.ReadFile(filepath.Clean(expandedPath))
+	if err != nil {
+		return "", fmt.Errorf("failed to read passphrase file %s: %w", expandedPath, err)
+	}
+
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolve returns the passphrase provided by the source
+func (s *PassphraseSource) resolve() (string, error) {
+	switch s.Type {
+	case envSource:
+		return readPassphrase(s.Name, "")
+	case fileSource:
+		return readPassphrase("", s.Path)
+	case callbackSource:
+		if s.Callback == nil {
+			return "", fmt.Errorf("%w: passphrase callback is not set", ErrInvalidConfig)
+		}
+		return s.Callback()
+	default:
+		return "", fmt.Errorf("%w: invalid passphrase source type: %s", ErrInvalidConfig, s.Type)
+	}
+}
+
 func expandPath(path string) (string, error) {
 	if path == "" {
 		return "", nil
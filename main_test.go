@@ -0,0 +1,22 @@
+package vault_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain lets the test binary stand in for the helper processes that the tests start: it runs as a fake age
+// plugin when invoked under the plugin's name, and as a vault plugin when the test plugin variable is set.
+func TestMain(m *testing.M) {
+	if filepath.Base(os.Args[0]) == "age-plugin-"+fakePluginName {
+		runFakeAgePlugin(strings.TrimPrefix(os.Args[1], "--age-plugin="))
+		os.Exit(0)
+	}
+	if os.Getenv(testPluginEnv) != "" {
+		runTestPlugin()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
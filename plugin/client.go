@@ -0,0 +1,235 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// ErrClosed is returned for calls made after the client is closed or the plugin has exited
+var ErrClosed = errors.New("plugin is not running")
+
+// shutdownTimeout is how long a plugin is given to exit after its stdin is closed before it is killed
+const shutdownTimeout = 5 * time.Second
+
+// Client calls a plugin process. It is safe for concurrent use.
+type Client struct {
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	writeMu sync.Mutex
+	enc     *json.Encoder
+
+	mu      sync.Mutex
+	nextID  uint64
+	pending map[uint64]chan response
+	err     error
+	exited  chan struct{}
+
+	capabilities Capabilities
+}
+
+// Start starts the plugin command and negotiates the protocol version. Unless the command's stderr is set, it is
+// passed through to stderr of the current process.
+func Start(ctx context.Context, cmd *exec.Cmd) (*Client, error) {
+	if cmd.Stderr == nil {
+		cmd.Stderr = os.Stderr
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting plugin: %w", err)
+	}
+
+	c := NewClient(stdin, stdout)
+	c.cmd = cmd
+	go func() {
+		<-c.exited
+		_ = cmd.Wait()
+	}()
+
+	if err := c.negotiate(ctx); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// NewClient returns a client that writes requests to w and reads responses from r. Use Start to run a plugin
+// process; NewClient is useful to connect to a plugin served in the same process, e.g. in tests.
+func NewClient(w io.WriteCloser, r io.Reader) *Client {
+	c := &Client{
+		stdin:   w,
+		enc:     json.NewEncoder(w),
+		pending: make(map[uint64]chan response),
+		exited:  make(chan struct{}),
+	}
+	go c.read(r)
+	return c
+}
+
+// negotiate checks that the plugin speaks the same protocol version and records its capabilities
+func (c *Client) negotiate(ctx context.Context) error {
+	var caps Capabilities
+	params := CapabilitiesParams{ProtocolVersion: ProtocolVersion}
+	if err := c.Call(ctx, MethodCapabilities, params, &caps); err != nil {
+		return fmt.Errorf("negotiating protocol: %w", err)
+	}
+	if caps.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("unsupported plugin protocol version %d, expected %d", caps.ProtocolVersion, ProtocolVersion)
+	}
+	c.capabilities = caps
+	return nil
+}
+
+// Capabilities returns the capabilities reported by the plugin when it was started
+func (c *Client) Capabilities() Capabilities {
+	return c.capabilities
+}
+
+// Call calls the method with the given parameters and decodes its result into result, which may be nil
+func (c *Client) Call(ctx context.Context, method string, params, result interface{}) error {
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("encoding %s params: %w", method, err)
+	}
+
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return c.err
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan response, 1)
+	c.pending[id] = ch
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.write(ctx, request{JSONRPC: jsonRPCVersion, ID: id, Method: method, Params: raw}); err != nil {
+		return err
+	}
+
+	var resp response
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.exited:
+		// the plugin may have responded just before it exited
+		select {
+		case resp = <-ch:
+		default:
+			return c.closedErr()
+		}
+	case resp = <-ch:
+	}
+
+	if resp.Error != nil {
+		return resp.Error
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("decoding %s result: %w", method, err)
+	}
+	return nil
+}
+
+// write sends the request to the plugin. The request is written in the background so that a plugin that stops
+// reading its stdin cannot block the caller past its context; an abandoned write still completes before the next
+// request is written, which keeps the stream intact.
+func (c *Client) write(ctx context.Context, req request) error {
+	done := make(chan error, 1)
+	go func() {
+		c.writeMu.Lock()
+		defer c.writeMu.Unlock()
+		done <- c.enc.Encode(req)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.exited:
+		select {
+		case err = <-done:
+		default:
+			return c.closedErr()
+		}
+	case err = <-done:
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrClosed, err)
+	}
+	return nil
+}
+
+// closedErr returns the reason the client stopped accepting calls
+func (c *Client) closedErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+// read dispatches responses to the pending calls until the plugin closes its stdout
+func (c *Client) read(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		var resp response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			continue
+		}
+		c.mu.Lock()
+		ch, ok := c.pending[resp.ID]
+		c.mu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	c.mu.Unlock()
+	close(c.exited)
+}
+
+// Close closes the plugin's stdin and waits for it to exit, killing it if it does not exit in time
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = ErrClosed
+	}
+	c.mu.Unlock()
+
+	err := c.stdin.Close()
+	if c.cmd == nil {
+		return err
+	}
+	select {
+	case <-c.exited:
+	case <-time.After(shutdownTimeout):
+		_ = c.cmd.Process.Kill()
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Memory is a reference plugin provider that keeps secrets in memory. It supports every operation of the protocol.
+type Memory struct {
+	mu      sync.RWMutex
+	secrets map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{secrets: make(map[string][]byte)}
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	value, ok := m.secrets[key]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (m *Memory) Set(_ context.Context, key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.secrets[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.secrets[key]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	delete(m.secrets, key)
+	return nil
+}
+
+func (m *Memory) List(context.Context) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]string, 0, len(m.secrets))
+	for k := range m.secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *Memory) Has(_ context.Context, key string) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.secrets[key]
+	return ok, nil
+}
+
+func (m *Memory) Metadata(context.Context) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return fmt.Sprintf("in-memory store with %d secrets", len(m.secrets)), nil
+}
@@ -0,0 +1,192 @@
+package plugin_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/flowexec/vault/plugin"
+)
+
+// getOnly is a provider that supports only the required operations
+type getOnly struct{}
+
+func (getOnly) Get(context.Context, string) ([]byte, error) {
+	return nil, plugin.ErrUnavailable
+}
+
+func (getOnly) Set(context.Context, string, []byte) error {
+	return errors.New("read only")
+}
+
+func servePipe(t *testing.T, p plugin.Provider) *plugin.Client {
+	t.Helper()
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- plugin.ServeIO(context.Background(), p, reqR, respW)
+		_ = respW.Close()
+	}()
+
+	client := plugin.NewClient(reqW, respR)
+	t.Cleanup(func() {
+		_ = client.Close()
+		if err := <-done; err != nil {
+			t.Errorf("ServeIO failed: %v", err)
+		}
+	})
+	return client
+}
+
+func TestMemoryPlugin(t *testing.T) {
+	ctx := context.Background()
+	client := servePipe(t, plugin.NewMemory())
+
+	var caps plugin.Capabilities
+	params := plugin.CapabilitiesParams{ProtocolVersion: plugin.ProtocolVersion}
+	if err := client.Call(ctx, plugin.MethodCapabilities, params, &caps); err != nil {
+		t.Fatalf("Failed to get capabilities: %v", err)
+	}
+	if caps.ProtocolVersion != plugin.ProtocolVersion {
+		t.Errorf("Expected protocol version %d, got %d", plugin.ProtocolVersion, caps.ProtocolVersion)
+	}
+	for _, method := range []string{
+		plugin.MethodGet, plugin.MethodSet, plugin.MethodDelete, plugin.MethodList, plugin.MethodHas,
+		plugin.MethodMetadata,
+	} {
+		if !caps.Supports(method) {
+			t.Errorf("Expected memory plugin to support %s", method)
+		}
+	}
+
+	set := plugin.SetParams{Key: "api-key", Value: []byte("s3cr3t")}
+	if err := client.Call(ctx, plugin.MethodSet, set, nil); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+
+	var got plugin.GetResult
+	if err := client.Call(ctx, plugin.MethodGet, plugin.KeyParams{Key: "api-key"}, &got); err != nil {
+		t.Fatalf("Failed to get secret: %v", err)
+	}
+	if string(got.Value) != "s3cr3t" {
+		t.Errorf("Expected value s3cr3t, got %q", got.Value)
+	}
+
+	var list plugin.ListResult
+	if err := client.Call(ctx, plugin.MethodList, struct{}{}, &list); err != nil {
+		t.Fatalf("Failed to list secrets: %v", err)
+	}
+	if !slices.Equal(list.Keys, []string{"api-key"}) {
+		t.Errorf("Expected keys [api-key], got %v", list.Keys)
+	}
+
+	if err := client.Call(ctx, plugin.MethodDelete, plugin.KeyParams{Key: "api-key"}, nil); err != nil {
+		t.Fatalf("Failed to delete secret: %v", err)
+	}
+
+	var has plugin.HasResult
+	if err := client.Call(ctx, plugin.MethodHas, plugin.KeyParams{Key: "api-key"}, &has); err != nil {
+		t.Fatalf("Failed to check secret: %v", err)
+	}
+	if has.Exists {
+		t.Error("Expected deleted secret not to exist")
+	}
+
+	err := client.Call(ctx, plugin.MethodGet, plugin.KeyParams{Key: "api-key"}, &got)
+	if !errors.Is(err, plugin.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestPluginErrors(t *testing.T) {
+	ctx := context.Background()
+	client := servePipe(t, getOnly{})
+
+	var caps plugin.Capabilities
+	params := plugin.CapabilitiesParams{ProtocolVersion: plugin.ProtocolVersion}
+	if err := client.Call(ctx, plugin.MethodCapabilities, params, &caps); err != nil {
+		t.Fatalf("Failed to get capabilities: %v", err)
+	}
+	if caps.Supports(plugin.MethodDelete) || caps.Supports(plugin.MethodList) {
+		t.Errorf("Expected only get and set to be supported, got %v", caps.Methods)
+	}
+
+	var pluginErr *plugin.Error
+	err := client.Call(ctx, plugin.MethodCapabilities, plugin.CapabilitiesParams{ProtocolVersion: 99}, &caps)
+	if !errors.As(err, &pluginErr) || pluginErr.Code != plugin.CodeInvalidRequest {
+		t.Errorf("Expected invalid request for unsupported protocol version, got %v", err)
+	}
+
+	err = client.Call(ctx, plugin.MethodDelete, plugin.KeyParams{Key: "key"}, nil)
+	if !errors.Is(err, plugin.ErrMethodNotFound) {
+		t.Errorf("Expected ErrMethodNotFound for unsupported operation, got %v", err)
+	}
+
+	err = client.Call(ctx, plugin.MethodGet, plugin.KeyParams{Key: "key"}, &plugin.GetResult{})
+	if !errors.Is(err, plugin.ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+
+	// errors without a protocol code are reported as internal errors
+	pluginErr = nil
+	err = client.Call(ctx, plugin.MethodSet, plugin.SetParams{Key: "key"}, nil)
+	if !errors.As(err, &pluginErr) || pluginErr.Code != plugin.CodeInternalError {
+		t.Errorf("Expected internal error, got %v", err)
+	}
+	if pluginErr != nil && pluginErr.Message != "read only" {
+		t.Errorf("Expected error message to be passed through, got %q", pluginErr.Message)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatalf("Failed to close client: %v", err)
+	}
+	if err := client.Call(ctx, plugin.MethodGet, plugin.KeyParams{Key: "key"}, nil); !errors.Is(err, plugin.ErrClosed) {
+		t.Errorf("Expected ErrClosed after close, got %v", err)
+	}
+}
+
+func TestClientCall(t *testing.T) {
+	t.Run("response before exit", func(t *testing.T) {
+		reqR, reqW := io.Pipe()
+		respR, respW := io.Pipe()
+		go func() {
+			// answer the request, then exit straight away
+			_, _ = bufio.NewReader(reqR).ReadBytes('\n')
+			_, _ = io.WriteString(respW, `{"jsonrpc":"2.0","id":1,"result":{"value":"czNjcjN0"}}`+"\n")
+			_ = respW.Close()
+		}()
+
+		client := plugin.NewClient(reqW, respR)
+		defer client.Close()
+		var got plugin.GetResult
+		if err := client.Call(context.Background(), plugin.MethodGet, plugin.KeyParams{Key: "key"}, &got); err != nil {
+			t.Fatalf("Expected the response to be returned, got %v", err)
+		}
+		if string(got.Value) != "s3cr3t" {
+			t.Errorf("Expected value s3cr3t, got %q", got.Value)
+		}
+	})
+
+	t.Run("blocked write", func(t *testing.T) {
+		// nothing reads the requests, so writing one blocks
+		_, reqW := io.Pipe()
+		respR, respW := io.Pipe()
+		client := plugin.NewClient(reqW, respR)
+		defer func() {
+			_ = respW.Close()
+			_ = client.Close()
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := client.Call(ctx, plugin.MethodGet, plugin.KeyParams{Key: "key"}, nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the call to stop at the deadline, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,140 @@
+// Package plugin implements the protocol spoken between the external vault provider and provider plugins.
+//
+// A plugin is a long-lived process that reads JSON-RPC 2.0 requests from stdin and writes responses to stdout, one
+// JSON object per line. The provider first calls the capabilities method to agree on the protocol version and learn
+// which operations the plugin supports. Plugins are written by implementing Provider and calling Serve.
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ProtocolVersion is the version of the plugin protocol implemented by this package.
+const ProtocolVersion = 1
+
+const jsonRPCVersion = "2.0"
+
+// Methods of the plugin protocol
+const (
+	MethodCapabilities = "vault.capabilities"
+	MethodGet          = "vault.get"
+	MethodSet          = "vault.set"
+	MethodDelete       = "vault.delete"
+	MethodList         = "vault.list"
+	MethodHas          = "vault.has"
+	MethodMetadata     = "vault.metadata"
+)
+
+// Error codes returned by plugins. The JSON-RPC reserved codes are used for protocol errors, and the codes below
+// for errors of the secret store.
+const (
+	CodeParseError     = -32700
+	CodeInvalidRequest = -32600
+	CodeMethodNotFound = -32601
+	CodeInvalidParams  = -32602
+	CodeInternalError  = -32603
+
+	CodeNotFound    = -32001
+	CodeNoAccess    = -32002
+	CodeInvalidKey  = -32003
+	CodeUnavailable = -32004
+)
+
+// Errors a Provider returns to report a failure with a specific code. Other errors are reported as internal errors.
+var (
+	ErrNotFound       = &Error{Code: CodeNotFound, Message: "secret not found"}
+	ErrNoAccess       = &Error{Code: CodeNoAccess, Message: "access denied"}
+	ErrInvalidKey     = &Error{Code: CodeInvalidKey, Message: "invalid secret key"}
+	ErrUnavailable    = &Error{Code: CodeUnavailable, Message: "provider unavailable"}
+	ErrMethodNotFound = &Error{Code: CodeMethodNotFound, Message: "method not supported"}
+)
+
+// Error is a JSON-RPC error returned by a plugin
+type Error struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("plugin error %d: %s", e.Code, e.Message)
+}
+
+// Is reports whether the target is an Error with the same code, so that errors.Is(err, ErrNotFound) holds for any
+// not found error regardless of its message.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	return errors.As(target, &t) && t.Code == e.Code
+}
+
+// request is a JSON-RPC request
+type request struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+// response is a JSON-RPC response
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *Error          `json:"error,omitempty"`
+}
+
+// CapabilitiesParams are the parameters of the capabilities method
+type CapabilitiesParams struct {
+	// ProtocolVersion is the protocol version spoken by the caller. Plugins reject versions they do not speak.
+	ProtocolVersion int `json:"protocol_version"`
+}
+
+// Capabilities describe a plugin
+type Capabilities struct {
+	// ProtocolVersion is the protocol version spoken by the plugin
+	ProtocolVersion int `json:"protocol_version"`
+	// Methods are the methods the plugin supports
+	Methods []string `json:"methods"`
+}
+
+// Supports reports whether the plugin supports the method
+func (c Capabilities) Supports(method string) bool {
+	for _, m := range c.Methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyParams are the parameters of the get, delete and has methods
+type KeyParams struct {
+	Key string `json:"key"`
+}
+
+// SetParams are the parameters of the set method
+type SetParams struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// GetResult is the result of the get method
+type GetResult struct {
+	Value []byte `json:"value"`
+}
+
+// ListResult is the result of the list method
+type ListResult struct {
+	Keys []string `json:"keys"`
+}
+
+// HasResult is the result of the has method
+type HasResult struct {
+	Exists bool `json:"exists"`
+}
+
+// MetadataResult is the result of the metadata method
+type MetadataResult struct {
+	Data string `json:"data"`
+}
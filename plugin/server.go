@@ -0,0 +1,212 @@
+package plugin
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Provider is the secret store behind a plugin. Every plugin supports getting and setting secrets; the other
+// operations are supported by also implementing Deleter, Lister, Checker or MetadataProvider.
+type Provider interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte) error
+}
+
+type Deleter interface {
+	Delete(ctx context.Context, key string) error
+}
+
+type Lister interface {
+	List(ctx context.Context) ([]string, error)
+}
+
+// Checker is implemented by plugins that can check for a secret without reading its value
+type Checker interface {
+	Has(ctx context.Context, key string) (bool, error)
+}
+
+type MetadataProvider interface {
+	Metadata(ctx context.Context) (string, error)
+}
+
+// Serve serves the provider over stdin and stdout until stdin is closed
+func Serve(p Provider) error {
+	return ServeIO(context.Background(), p, os.Stdin, os.Stdout)
+}
+
+// ServeIO serves the provider, reading requests from r and writing responses to w, until r is exhausted or the
+// context is canceled. Requests are handled concurrently.
+func ServeIO(ctx context.Context, p Provider, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := &server{provider: p, enc: json.NewEncoder(w)}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxMessageSize)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			s.reply(response{Error: &Error{Code: CodeParseError, Message: err.Error()}})
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, req)
+		}()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("reading requests: %w", err)
+	}
+	return nil
+}
+
+// maxMessageSize is the largest request or response accepted on the wire
+const maxMessageSize = 16 * 1024 * 1024
+
+type server struct {
+	provider Provider
+
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func (s *server) reply(resp response) {
+	resp.JSONRPC = jsonRPCVersion
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.enc.Encode(resp)
+}
+
+func (s *server) handle(ctx context.Context, req request) {
+	resp := response{ID: req.ID}
+	if req.JSONRPC != jsonRPCVersion {
+		resp.Error = &Error{Code: CodeInvalidRequest, Message: "unsupported JSON-RPC version"}
+		s.reply(resp)
+		return
+	}
+
+	result, err := s.call(ctx, req.Method, req.Params)
+	if err != nil {
+		resp.Error = toError(err)
+	} else if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = &Error{Code: CodeInternalError, Message: err.Error()}
+	}
+	s.reply(resp)
+}
+
+func (s *server) call(ctx context.Context, method string, params json.RawMessage) (interface{}, error) {
+	switch method {
+	case MethodCapabilities:
+		var p CapabilitiesParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		if p.ProtocolVersion != ProtocolVersion {
+			return nil, &Error{
+				Code:    CodeInvalidRequest,
+				Message: fmt.Sprintf("unsupported protocol version %d, expected %d", p.ProtocolVersion, ProtocolVersion),
+			}
+		}
+		return s.capabilities(), nil
+	case MethodGet:
+		var p KeyParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		value, err := s.provider.Get(ctx, p.Key)
+		return GetResult{Value: value}, err
+	case MethodSet:
+		var p SetParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return struct{}{}, s.provider.Set(ctx, p.Key, p.Value)
+	case MethodDelete:
+		d, ok := s.provider.(Deleter)
+		if !ok {
+			return nil, ErrMethodNotFound
+		}
+		var p KeyParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return struct{}{}, d.Delete(ctx, p.Key)
+	case MethodList:
+		l, ok := s.provider.(Lister)
+		if !ok {
+			return nil, ErrMethodNotFound
+		}
+		keys, err := l.List(ctx)
+		return ListResult{Keys: keys}, err
+	case MethodHas:
+		c, ok := s.provider.(Checker)
+		if !ok {
+			return nil, ErrMethodNotFound
+		}
+		var p KeyParams
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		exists, err := c.Has(ctx, p.Key)
+		return HasResult{Exists: exists}, err
+	case MethodMetadata:
+		m, ok := s.provider.(MetadataProvider)
+		if !ok {
+			return nil, ErrMethodNotFound
+		}
+		data, err := m.Metadata(ctx)
+		return MetadataResult{Data: data}, err
+	default:
+		return nil, ErrMethodNotFound
+	}
+}
+
+func (s *server) capabilities() Capabilities {
+	methods := []string{MethodCapabilities, MethodGet, MethodSet}
+	if _, ok := s.provider.(Deleter); ok {
+		methods = append(methods, MethodDelete)
+	}
+	if _, ok := s.provider.(Lister); ok {
+		methods = append(methods, MethodList)
+	}
+	if _, ok := s.provider.(Checker); ok {
+		methods = append(methods, MethodHas)
+	}
+	if _, ok := s.provider.(MetadataProvider); ok {
+		methods = append(methods, MethodMetadata)
+	}
+	return Capabilities{ProtocolVersion: ProtocolVersion, Methods: methods}
+}
+
+func decodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return &Error{Code: CodeInvalidParams, Message: err.Error()}
+	}
+	return nil
+}
+
+// toError converts an error returned by the provider into a protocol error, keeping the code of a wrapped Error
+func toError(err error) *Error {
+	code := CodeInternalError
+	var e *Error
+	if errors.As(err, &e) {
+		if e == err {
+			return e
+		}
+		code = e.Code
+	}
+	return &Error{Code: code, Message: err.Error()}
+}
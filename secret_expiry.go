@@ -0,0 +1,54 @@
+package vault
+
+import (
+	"sort"
+	"time"
+)
+
+// SetOptions configures how a secret is written
+type SetOptions struct {
+	// ExpiresAt is the time after which the secret can no longer be read. The zero value never expires.
+	ExpiresAt time.Time
+	// Recipients restricts the secret to a subset of the vault recipients. It is only supported by age vaults
+	// with per-secret encryption; when empty, the secret keeps its current recipients.
+	Recipients []string
+}
+
+// ExpiryManager is implemented by providers that support secrets with an expiration time. Reading an expired
+// secret returns ErrSecretExpired until it is overwritten, deleted, or purged. Overwriting a secret with SetSecret
+// clears its expiration.
+type ExpiryManager interface {
+	SetSecretWithOptions(key string, value Secret, opts SetOptions) error
+	// PurgeExpired removes all expired secrets from the vault and returns their keys
+	PurgeExpired() ([]string, error)
+}
+
+func HasExpiryManagement(v Provider) (ExpiryManager, bool) {
+	em, ok := v.(ExpiryManager)
+	return em, ok
+}
+
+func (o SetOptions) expiresAt() *time.Time {
+	if o.ExpiresAt.IsZero() {
+		return nil
+	}
+	expiry := o.ExpiresAt
+	return &expiry
+}
+
+func (e *SecretEntry) expired() bool {
+	return e.Metadata.ExpiresAt != nil && !time.Now().Before(*e.Metadata.ExpiresAt)
+}
+
+// purgeExpired deletes the expired entries and returns their keys in sorted order
+func purgeExpired(entries map[string]*SecretEntry) []string {
+	purged := make([]string, 0)
+	for k, entry := range entries {
+		if entry.expired() {
+			delete(entries, k)
+			purged = append(purged, k)
+		}
+	}
+	sort.Strings(purged)
+	return purged
+}
@@ -0,0 +1,107 @@
+package vault_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/flowexec/vault"
+)
+
+func TestSecretExpiry(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string) vault.Provider
+	}{
+		{name: "AES256 Vault", setup: setupAESVault},
+		{name: "Age Vault", setup: setupAgeVault},
+		{name: "Unencrypted Vault", setup: setupUnencryptedVault},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			v := tt.setup(t, dir)
+
+			em, ok := vault.HasExpiryManagement(v)
+			if !ok {
+				t.Fatal("Expected provider to support secret expiry")
+			}
+
+			past := vault.SetOptions{ExpiresAt: time.Now().Add(-time.Minute)}
+			future := vault.SetOptions{ExpiresAt: time.Now().Add(time.Hour)}
+			if err := em.SetSecretWithOptions("stale", vault.NewSecretValue([]byte("old")), past); err != nil {
+				t.Fatalf("Failed to set expired secret: %v", err)
+			}
+			if err := em.SetSecretWithOptions("fresh", vault.NewSecretValue([]byte("new")), future); err != nil {
+				t.Fatalf("Failed to set secret: %v", err)
+			}
+			if err := v.SetSecret("forever", vault.NewSecretValue([]byte("value"))); err != nil {
+				t.Fatalf("Failed to set secret: %v", err)
+			}
+
+			_ = v.Close()
+			v = tt.setup(t, dir)
+			defer v.Close()
+			em, _ = vault.HasExpiryManagement(v)
+
+			if _, err := v.GetSecret("stale"); !errors.Is(err, vault.ErrSecretExpired) {
+				t.Errorf("Expected ErrSecretExpired, got: %v", err)
+			}
+			vm, ok := vault.HasVersionManagement(v)
+			if !ok {
+				t.Fatal("Expected provider to support secret versions")
+			}
+			if _, err := vm.GetSecretVersion("stale", 1); !errors.Is(err, vault.ErrSecretExpired) {
+				t.Errorf("Expected ErrSecretExpired reading the current version, got: %v", err)
+			}
+			if secret, err := v.GetSecret("fresh"); err != nil || secret.PlainTextString() != "new" {
+				t.Errorf("Expected unexpired secret to be readable, got %v, %v", secret, err)
+			}
+
+			purged, err := em.PurgeExpired()
+			if err != nil {
+				t.Fatalf("Failed to purge expired secrets: %v", err)
+			}
+			if len(purged) != 1 || purged[0] != "stale" {
+				t.Errorf("Expected only 'stale' to be purged, got %v", purged)
+			}
+			if _, err := v.GetSecret("stale"); !errors.Is(err, vault.ErrSecretNotFound) {
+				t.Errorf("Expected ErrSecretNotFound after purge, got: %v", err)
+			}
+
+			keys, _ := v.ListSecrets()
+			if len(keys) != 2 {
+				t.Errorf("Expected 2 remaining secrets, got %v", keys)
+			}
+		})
+	}
+}
+
+func TestSecretExpiryClearedOnOverwrite(t *testing.T) {
+	v := setupUnencryptedVault(t, t.TempDir())
+	defer v.Close()
+
+	em, _ := vault.HasExpiryManagement(v)
+	opts := vault.SetOptions{ExpiresAt: time.Now().Add(-time.Second)}
+	if err := em.SetSecretWithOptions("key", vault.NewSecretValue([]byte("old")), opts); err != nil {
+		t.Fatalf("Failed to set secret: %v", err)
+	}
+	if err := v.SetSecret("key", vault.NewSecretValue([]byte("new"))); err != nil {
+		t.Fatalf("Failed to overwrite secret: %v", err)
+	}
+
+	secret, err := v.GetSecret("key")
+	if err != nil {
+		t.Fatalf("Expected overwritten secret to be readable: %v", err)
+	}
+	if secret.PlainTextString() != "new" {
+		t.Errorf("Expected 'new', got %q", secret.PlainTextString())
+	}
+
+	mm, _ := vault.HasSecretMetadata(v)
+	md, _ := mm.GetSecretMetadata("key")
+	if md.ExpiresAt != nil {
+		t.Errorf("Expected expiry to be cleared, got %v", md.ExpiresAt)
+	}
+}
@@ -0,0 +1,105 @@
+package vault
+
+import (
+	"fmt"
+	"time"
+)
+
+// SecretVersion is a prior value of a secret retained in the vault history
+type SecretVersion struct {
+	Version   int       `json:"version" yaml:"version"`
+	Value     string    `json:"value" yaml:"value"`
+	Updated   time.Time `json:"updated" yaml:"updated"`
+	UpdatedBy string    `json:"updatedBy,omitempty" yaml:"updatedBy,omitempty"`
+}
+
+// SecretVersionInfo describes a version of a secret without exposing its value
+type SecretVersionInfo struct {
+	Version   int       `json:"version"`
+	Updated   time.Time `json:"updated"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
+	Current   bool      `json:"current"`
+}
+
+// VersionManager is implemented by providers that retain prior values of their secrets. The number of versions
+// kept is controlled by the history size of the vault configuration.
+type VersionManager interface {
+	GetSecretVersion(key string, version int) (Secret, error)
+	ListSecretVersions(key string) ([]SecretVersionInfo, error)
+	RollbackSecret(key string, version int) error
+}
+
+func HasVersionManagement(v Provider) (VersionManager, bool) {
+	vm, ok := v.(VersionManager)
+	return vm, ok
+}
+
+// currentVersion returns the version number of the current value. Entries written before versioning was
+// introduced have no recorded version and are treated as the first.
+func (e *SecretEntry) currentVersion() int {
+	if e.Version == 0 {
+		return 1
+	}
+	return e.Version
+}
+
+// valueAt returns the value of the given version of the secret, which may be the current value
+func (e *SecretEntry) valueAt(key string, version int) (string, error) {
+	if version == e.currentVersion() {
+		return e.Value, nil
+	}
+	for _, prior := range e.History {
+		if prior.Version == version {
+			return prior.Value, nil
+		}
+	}
+	return "", fmt.Errorf("%w: version %d of %s", ErrSecretNotFound, version, key)
+}
+
+// readVersion returns the value of the given version like valueAt, but refuses the current value of an expired
+// secret in the same way that reading the secret does
+func (e *SecretEntry) readVersion(key string, version int) (string, error) {
+	if version == e.currentVersion() && e.expired() {
+		return "", ErrSecretExpired
+	}
+	return e.valueAt(key, version)
+}
+
+// versions lists the retained versions of the secret, newest first
+func (e *SecretEntry) versions() []SecretVersionInfo {
+	result := make([]SecretVersionInfo, 0, len(e.History)+1)
+	result = append(result, SecretVersionInfo{
+		Version:   e.currentVersion(),
+		Updated:   e.Metadata.Updated,
+		UpdatedBy: e.Metadata.UpdatedBy,
+		Current:   true,
+	})
+	for i := len(e.History) - 1; i >= 0; i-- {
+		result = append(result, SecretVersionInfo{
+			Version:   e.History[i].Version,
+			Updated:   e.History[i].Updated,
+			UpdatedBy: e.History[i].UpdatedBy,
+		})
+	}
+	return result
+}
+
+// rollback writes the value of a prior version as a new version of the secret
+func (e *SecretEntry) rollback(key string, version, historySize int) error {
+	if version == e.currentVersion() {
+		return nil
+	}
+	value, err := e.valueAt(key, version)
+	if err != nil {
+		return err
+	}
+	e.setValue(value, historySize)
+	return nil
+}
+
+func validateHistorySize(size int) error {
+	if size < 0 {
+		return fmt.Errorf("%w: history size cannot be negative", ErrInvalidConfig)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package vault_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/flowexec/vault"
+)
+
+func TestSecretVersionHistory(t *testing.T) {
+	tests := []struct {
+		name string
+		opts func(t *testing.T, dir string) []vault.Option
+	}{
+		{
+			name: "AES256 Vault",
+			opts: func(t *testing.T, dir string) []vault.Option {
+				key, err := vault.GenerateEncryptionKey()
+				if err != nil {
+					t.Fatalf("Failed to generate key: %v", err)
+				}
+				t.Setenv("HISTORY_TEST_KEY", key)
+				return []vault.Option{
+					vault.WithProvider(vault.ProviderTypeAES256),
+					vault.WithAESPath(dir),
+					vault.WithAESKeyFromEnv("HISTORY_TEST_KEY"),
+				}
+			},
+		},
+		{
+			name: "Age Vault",
+			opts: func(t *testing.T, dir string) []vault.Option {
+				keyFile := filepath.Join(dir, "test-key.txt")
+				identity := "AGE-SECRET-KEY-1LC563A3EG4TLDL5EQE0YP5ZSJW8NADURXLZ8WVM00DMKG60URRNQ5TRZH0"
+				if err := os.WriteFile(keyFile, []byte(identity), 0600); err != nil {
+					t.Fatalf("Failed to write identity file: %v", err)
+				}
+				return []vault.Option{
+					vault.WithProvider(vault.ProviderTypeAge),
+					vault.WithAgePath(dir),
+					vault.WithAgeIdentityFromFile(keyFile),
+					vault.WithAgeRecipients("age1wnhg53pg2qfsfxwvxvlg6pygw5uzwcyhj2dqhg0k83fvjexf9pzsxqdvs0"),
+				}
+			},
+		},
+		{
+			name: "Unencrypted Vault",
+			opts: func(t *testing.T, dir string) []vault.Option {
+				return []vault.Option{
+					vault.WithProvider(vault.ProviderTypeUnencrypted),
+					vault.WithUnencryptedPath(dir),
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			opts := append(tt.opts(t, dir), vault.WithHistorySize(2))
+
+			v, _, err := vault.New("history-test", opts...)
+			if err != nil {
+				t.Fatalf("Failed to create vault: %v", err)
+			}
+
+			for _, value := range []string{"one", "two", "three", "four"} {
+				if err := v.SetSecret("rotated", vault.NewSecretValue([]byte(value))); err != nil {
+					t.Fatalf("Failed to set secret: %v", err)
+				}
+			}
+			_ = v.Close()
+
+			v, _, err = vault.New("history-test", opts...)
+			if err != nil {
+				t.Fatalf("Failed to reopen vault: %v", err)
+			}
+			defer v.Close()
+
+			vm, ok := vault.HasVersionManagement(v)
+			if !ok {
+				t.Fatal("Expected provider to support version management")
+			}
+
+			versions, err := vm.ListSecretVersions("rotated")
+			if err != nil {
+				t.Fatalf("Failed to list versions: %v", err)
+			}
+			if len(versions) != 3 {
+				t.Fatalf("Expected current version plus 2 retained versions, got %d", len(versions))
+			}
+			if !versions[0].Current || versions[0].Version != 4 || versions[2].Version != 2 {
+				t.Errorf("Unexpected version listing: %+v", versions)
+			}
+
+			old, err := vm.GetSecretVersion("rotated", 3)
+			if err != nil {
+				t.Fatalf("Failed to get version 3: %v", err)
+			}
+			if old.PlainTextString() != "three" {
+				t.Errorf("Expected 'three', got %q", old.PlainTextString())
+			}
+			if _, err := vm.GetSecretVersion("rotated", 1); !errors.Is(err, vault.ErrSecretNotFound) {
+				t.Errorf("Expected pruned version to be not found, got: %v", err)
+			}
+
+			if err := vm.RollbackSecret("rotated", 2); err != nil {
+				t.Fatalf("Failed to roll back secret: %v", err)
+			}
+			current, err := v.GetSecret("rotated")
+			if err != nil {
+				t.Fatalf("Failed to get secret: %v", err)
+			}
+			if current.PlainTextString() != "two" {
+				t.Errorf("Expected rolled back value 'two', got %q", current.PlainTextString())
+			}
+
+			versions, _ = vm.ListSecretVersions("rotated")
+			if versions[0].Version != 5 || versions[1].Version != 4 {
+				t.Errorf("Expected rollback to be recorded as a new version, got %+v", versions)
+			}
+		})
+	}
+}
+
+func TestSecretVersionHistoryDisabled(t *testing.T) {
+	v := setupUnencryptedVault(t, t.TempDir())
+	defer v.Close()
+
+	_ = v.SetSecret("key", vault.NewSecretValue([]byte("one")))
+	_ = v.SetSecret("key", vault.NewSecretValue([]byte("two")))
+
+	vm, _ := vault.HasVersionManagement(v)
+	versions, err := vm.ListSecretVersions("key")
+	if err != nil {
+		t.Fatalf("Failed to list versions: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Version != 2 {
+		t.Errorf("Expected only the current version to be kept, got %+v", versions)
+	}
+	if err := vm.RollbackSecret("key", 1); !errors.Is(err, vault.ErrSecretNotFound) {
+		t.Errorf("Expected ErrSecretNotFound for unretained version, got: %v", err)
+	}
+}
+
+func TestSecretVersionHistoryNegativeSize(t *testing.T) {
+	dir := t.TempDir()
+	_, err := vault.NewUnencryptedVault(&vault.Config{
+		ID:          "negative-history",
+		Type:        vault.ProviderTypeUnencrypted,
+		Unencrypted: &vault.UnencryptedConfig{StoragePath: dir, HistorySize: -1},
+	})
+	if !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a negative history size, got: %v", err)
+	}
+
+	_, err = vault.NewAgeVault(&vault.Config{
+		ID:   "negative-history",
+		Type: vault.ProviderTypeAge,
+		Age:  &vault.AgeConfig{StoragePath: dir, HistorySize: -1},
+	})
+	if !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a negative age history size, got: %v", err)
+	}
+
+	_, err = vault.NewAES256Vault(&vault.Config{
+		ID:   "negative-history",
+		Type: vault.ProviderTypeAES256,
+		Aes:  &vault.AesConfig{StoragePath: dir, HistorySize: -1},
+	})
+	if !errors.Is(err, vault.ErrInvalidConfig) {
+		t.Errorf("Expected ErrInvalidConfig for a negative AES history size, got: %v", err)
+	}
+}
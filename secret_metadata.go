@@ -0,0 +1,124 @@
+package vault
+
+import (
+	"os"
+	"os/user"
+	"time"
+)
+
+// SecretMetadata describes a single secret stored in a vault
+type SecretMetadata struct {
+	Created     time.Time `json:"created" yaml:"created"`
+	Updated     time.Time `json:"updated" yaml:"updated"`
+	Description string    `json:"description,omitempty" yaml:"description,omitempty"`
+	Tags        []string  `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// UpdatedBy is the identity of the user that last wrote the secret value
+	UpdatedBy string `json:"updatedBy,omitempty" yaml:"updatedBy,omitempty"`
+	// ExpiresAt is the time after which the secret can no longer be read
+	ExpiresAt *time.Time `json:"expiresAt,omitempty" yaml:"expiresAt,omitempty"`
+	// NeedsRotation is set when a recipient that could read the secret has been removed from the vault
+	NeedsRotation bool `json:"needsRotation,omitempty" yaml:"needsRotation,omitempty"`
+}
+
+// SecretEntry is the persisted record of a single secret in the file-backed vaults.
+type SecretEntry struct {
+	Value    string         `json:"value" yaml:"value"`
+	Metadata SecretMetadata `json:"metadata" yaml:"metadata"`
+
+	// Version is incremented each time the secret value is written
+	Version int `json:"version,omitempty" yaml:"version,omitempty"`
+	// History holds the prior values of the secret, oldest first
+	History []SecretVersion `json:"history,omitempty" yaml:"history,omitempty"`
+	// Recipients restricts the secret to a subset of the vault recipients in vaults that encrypt each secret
+	// on its own
+	Recipients []string `json:"recipients,omitempty" yaml:"recipients,omitempty"`
+}
+
+type SecretMetadataManager interface {
+	GetSecretMetadata(key string) (SecretMetadata, error)
+	ListSecretsWithMetadata() (map[string]SecretMetadata, error)
+	SetSecretMetadata(key, description string, tags []string) error
+}
+
+func HasSecretMetadata(v Provider) (SecretMetadataManager, bool) {
+	mm, ok := v.(SecretMetadataManager)
+	return mm, ok
+}
+
+func newSecretEntry(value string) *SecretEntry {
+	now := time.Now()
+	return &SecretEntry{
+		Value: value,
+		Metadata: SecretMetadata{
+			Created:   now,
+			Updated:   now,
+			UpdatedBy: currentWriter(),
+		},
+		Version: 1,
+	}
+}
+
+// setValue replaces the secret value and records the write in the entry metadata. Up to historySize prior
+// values are retained in the entry history.
+func (e *SecretEntry) setValue(value string, historySize int) {
+	e.History = append(e.History, SecretVersion{
+		Version:   e.currentVersion(),
+		Value:     e.Value,
+		Updated:   e.Metadata.Updated,
+		UpdatedBy: e.Metadata.UpdatedBy,
+	})
+	if historySize = max(historySize, 0); len(e.History) > historySize {
+		e.History = e.History[len(e.History)-historySize:]
+	}
+	if len(e.History) == 0 {
+		e.History = nil
+	}
+
+	e.Version = e.currentVersion() + 1
+	e.Value = value
+	e.Metadata.Updated = time.Now()
+	e.Metadata.UpdatedBy = currentWriter()
+}
+
+// copy returns a copy of the metadata that does not share memory with the vault state
+func (m SecretMetadata) copy() SecretMetadata {
+	if m.Tags != nil {
+		tags := make([]string, len(m.Tags))
+		copy(tags, m.Tags)
+		m.Tags = tags
+	}
+	return m
+}
+
+func secretsMetadata(entries map[string]*SecretEntry) map[string]SecretMetadata {
+	result := make(map[string]SecretMetadata, len(entries))
+	for k, entry := range entries {
+		result[k] = entry.Metadata.copy()
+	}
+	return result
+}
+
+// migrateSecretsV1 converts the version 1 secrets map, which only held values, into secret entries. The vault's
+// last modified time is the best available approximation of when each secret was written.
+func migrateSecretsV1(secrets map[string]string, modified time.Time) map[string]*SecretEntry {
+	entries := make(map[string]*SecretEntry, len(secrets))
+	for k, value := range secrets {
+		entries[k] = &SecretEntry{
+			Value: value,
+			Metadata: SecretMetadata{
+				Created: modified,
+				Updated: modified,
+			},
+			Version: 1,
+		}
+	}
+	return entries
+}
+
+// currentWriter returns the identity recorded as the writer of a secret
+func currentWriter() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
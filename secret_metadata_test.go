@@ -0,0 +1,197 @@
+package vault_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/flowexec/vault"
+	"github.com/flowexec/vault/crypto"
+)
+
+func setupUnencryptedVault(t *testing.T, dir string) vault.Provider {
+	v, _, err := vault.New("test-unencrypted",
+		vault.WithProvider(vault.ProviderTypeUnencrypted),
+		vault.WithUnencryptedPath(dir),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create unencrypted vault: %v", err)
+	}
+	return v
+}
+
+func TestSecretMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, dir string) vault.Provider
+	}{
+		{name: "AES256 Vault", setup: setupAESVault},
+		{name: "Age Vault", setup: setupAgeVault},
+		{name: "Unencrypted Vault", setup: setupUnencryptedVault},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			v := tt.setup(t, dir)
+
+			mm, ok := vault.HasSecretMetadata(v)
+			if !ok {
+				t.Fatal("Expected provider to support secret metadata")
+			}
+
+			if _, err := mm.GetSecretMetadata("missing"); !errors.Is(err, vault.ErrSecretNotFound) {
+				t.Errorf("Expected ErrSecretNotFound, got: %v", err)
+			}
+
+			if err := v.SetSecret("api-key", vault.NewSecretValue([]byte("v1"))); err != nil {
+				t.Fatalf("Failed to set secret: %v", err)
+			}
+			created, err := mm.GetSecretMetadata("api-key")
+			if err != nil {
+				t.Fatalf("Failed to get secret metadata: %v", err)
+			}
+			if created.Created.IsZero() || !created.Created.Equal(created.Updated) {
+				t.Errorf("Expected matching created and updated times, got %v and %v", created.Created, created.Updated)
+			}
+
+			if err := mm.SetSecretMetadata("api-key", "third-party API key", []string{"prod", "billing"}); err != nil {
+				t.Fatalf("Failed to set secret metadata: %v", err)
+			}
+			if err := v.SetSecret("api-key", vault.NewSecretValue([]byte("v2"))); err != nil {
+				t.Fatalf("Failed to update secret: %v", err)
+			}
+
+			_ = v.Close()
+			v = tt.setup(t, dir)
+			defer v.Close()
+			mm, _ = vault.HasSecretMetadata(v)
+
+			all, err := mm.ListSecretsWithMetadata()
+			if err != nil {
+				t.Fatalf("Failed to list secrets with metadata: %v", err)
+			}
+			md, exists := all["api-key"]
+			if !exists {
+				t.Fatal("Expected metadata for api-key")
+			}
+			if !md.Created.Equal(created.Created) {
+				t.Errorf("Created time should not change on update, got %v want %v", md.Created, created.Created)
+			}
+			if !md.Updated.After(md.Created) {
+				t.Errorf("Updated time should be after created time, got %v", md.Updated)
+			}
+			if md.Description != "third-party API key" {
+				t.Errorf("Expected description to persist, got %q", md.Description)
+			}
+			if len(md.Tags) != 2 || md.Tags[0] != "prod" || md.Tags[1] != "billing" {
+				t.Errorf("Expected tags to persist, got %v", md.Tags)
+			}
+		})
+	}
+}
+
+func TestSecretMetadataMigration(t *testing.T) {
+	t.Run("Unencrypted Vault", func(t *testing.T) {
+		dir := t.TempDir()
+		legacy := `{
+  "metadata": {"created": "2024-01-01T00:00:00Z", "lastModified": "2024-02-01T00:00:00Z"},
+  "version": 1,
+  "id": "test-unencrypted",
+  "secrets": {"legacy-key": "legacy-value"}
+}`
+		path := filepath.Join(dir, "vault-test-unencrypted.json")
+		if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+			t.Fatalf("Failed to write legacy vault: %v", err)
+		}
+
+		v := setupUnencryptedVault(t, dir)
+		defer v.Close()
+		assertMigratedSecret(t, v)
+	})
+
+	t.Run("AES256 Vault", func(t *testing.T) {
+		dir := t.TempDir()
+		key, err := vault.GenerateEncryptionKey()
+		if err != nil {
+			t.Fatalf("Failed to generate key: %v", err)
+		}
+		t.Setenv(vault.DefaultVaultKeyEnv, key)
+
+		legacy := `metadata:
+  created: 2024-01-01T00:00:00Z
+  lastmodified: 2024-02-01T00:00:00Z
+version: 1
+id: test-aes
+secrets:
+  legacy-key: legacy-value
+`
+		encrypted, err := crypto.EncryptValue(key, legacy)
+		if err != nil {
+			t.Fatalf("Failed to encrypt legacy vault: %v", err)
+		}
+		path := filepath.Join(dir, "vault-test-aes.enc")
+		if err := os.WriteFile(path, []byte(encrypted), 0600); err != nil {
+			t.Fatalf("Failed to write legacy vault: %v", err)
+		}
+
+		v := setupAESVault(t, dir)
+		defer v.Close()
+		assertMigratedSecret(t, v)
+	})
+
+	t.Run("Age Vault", func(t *testing.T) {
+		dir := t.TempDir()
+		recipient, err := age.ParseX25519Recipient("age1wnhg53pg2qfsfxwvxvlg6pygw5uzwcyhj2dqhg0k83fvjexf9pzsxqdvs0")
+		if err != nil {
+			t.Fatalf("Failed to parse recipient: %v", err)
+		}
+
+		legacy := `{
+  "metadata": {"created": "2024-01-01T00:00:00Z", "lastModified": "2024-02-01T00:00:00Z"},
+  "version": 1,
+  "id": "test-age",
+  "recipients": ["age1wnhg53pg2qfsfxwvxvlg6pygw5uzwcyhj2dqhg0k83fvjexf9pzsxqdvs0"],
+  "secrets": {"legacy-key": "legacy-value"}
+}`
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, recipient)
+		if err != nil {
+			t.Fatalf("Failed to create encryptor: %v", err)
+		}
+		_, _ = w.Write([]byte(legacy))
+		_ = w.Close()
+		if err := os.WriteFile(filepath.Join(dir, "vault-test-age.age"), buf.Bytes(), 0600); err != nil {
+			t.Fatalf("Failed to write legacy vault: %v", err)
+		}
+
+		v := setupAgeVault(t, dir)
+		defer v.Close()
+		assertMigratedSecret(t, v)
+	})
+}
+
+func assertMigratedSecret(t *testing.T, v vault.Provider) {
+	t.Helper()
+
+	secret, err := v.GetSecret("legacy-key")
+	if err != nil {
+		t.Fatalf("Failed to get migrated secret: %v", err)
+	}
+	if secret.PlainTextString() != "legacy-value" {
+		t.Errorf("Expected 'legacy-value', got %q", secret.PlainTextString())
+	}
+
+	mm, _ := vault.HasSecretMetadata(v)
+	md, err := mm.GetSecretMetadata("legacy-key")
+	if err != nil {
+		t.Fatalf("Failed to get migrated secret metadata: %v", err)
+	}
+	if md.Created.IsZero() || md.Created.Year() != 2024 {
+		t.Errorf("Expected migrated created time from vault metadata, got %v", md.Created)
+	}
+}
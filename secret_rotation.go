@@ -0,0 +1,39 @@
+package vault
+
+import (
+	"slices"
+)
+
+// RecipientRemovalReport lists the secrets that a removed recipient could read. A removed recipient may have kept
+// a copy of the vault file, so these secrets are marked as needing rotation until their values are replaced.
+type RecipientRemovalReport struct {
+	Recipient string `json:"recipient"`
+	// Secrets are the keys of the secrets the recipient could read, in sorted order
+	Secrets []string `json:"secrets"`
+}
+
+// RotationTracker is implemented by providers that track which secrets were exposed to a removed recipient.
+// Overwriting a secret with a new value clears its pending rotation; rolling back to a prior version does not.
+type RotationTracker interface {
+	// RemoveRecipientWithReport removes the recipient and reports the secrets it could read
+	RemoveRecipientWithReport(publicKey string) (RecipientRemovalReport, error)
+	// ListPendingRotations returns the keys of the secrets that need rotation, in sorted order
+	ListPendingRotations() ([]string, error)
+}
+
+func HasRotationTracking(v Provider) (RotationTracker, bool) {
+	rt, ok := v.(RotationTracker)
+	return rt, ok
+}
+
+// pendingRotations returns the keys of the entries marked as needing rotation in sorted order
+func pendingRotations(entries map[string]*SecretEntry) []string {
+	pending := make([]string, 0)
+	for k, entry := range entries {
+		if entry.Metadata.NeedsRotation {
+			pending = append(pending, k)
+		}
+	}
+	slices.Sort(pending)
+	return pending
+}
@@ -1,6 +1,7 @@
 package vault
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,7 +13,7 @@ import (
 )
 
 const (
-	unencryptedCurrentVaultVersion = 1
+	unencryptedCurrentVaultVersion = 2
 	unencryptedVaultFileExt        = "json"
 )
 
@@ -20,6 +21,15 @@ const (
 type UnencryptedState struct {
 	Metadata `json:"metadata"`
 
+	Version int                     `json:"version"`
+	ID      string                  `json:"id"`
+	Secrets map[string]*SecretEntry `json:"secrets"`
+}
+
+// unencryptedStateV1 is the version 1 layout of UnencryptedState, in which secrets were stored as bare values.
+type unencryptedStateV1 struct {
+	Metadata `json:"metadata"`
+
 	Version int               `json:"version"`
 	ID      string            `json:"id"`
 	Secrets map[string]string `json:"secrets"`
@@ -31,13 +41,17 @@ type UnencryptedVault struct {
 	id       string
 	fullPath string
 
-	state *UnencryptedState
+	state       *UnencryptedState
+	historySize int
 }
 
 func NewUnencryptedVault(cfg *Config) (*UnencryptedVault, error) {
 	if cfg.Unencrypted == nil {
 		return nil, fmt.Errorf("unencrypted configuration is required")
 	}
+	if err := validateHistorySize(cfg.Unencrypted.HistorySize); err != nil {
+		return nil, err
+	}
 
 	path := filepath.Join(
 		filepath.Clean(cfg.Unencrypted.StoragePath),
@@ -45,8 +59,9 @@ func NewUnencryptedVault(cfg *Config) (*UnencryptedVault, error) {
 	)
 
 	vault := &UnencryptedVault{
-		id:       cfg.ID,
-		fullPath: path,
+		id:          cfg.ID,
+		fullPath:    path,
+		historySize: cfg.Unencrypted.HistorySize,
 	}
 
 	if err := vault.load(); err != nil {
@@ -71,7 +86,7 @@ func (v *UnencryptedVault) init() error {
 			Created:      now,
 			LastModified: now,
 		},
-		Secrets: make(map[string]string),
+		Secrets: make(map[string]*SecretEntry),
 	}
 
 	return v.save()
@@ -91,16 +106,47 @@ func (v *UnencryptedVault) load() error {
 		return nil
 	}
 
-	// Parse the JSON format
-	var state UnencryptedState
-	if err := json.Unmarshal(data, &state); err != nil {
-		return fmt.Errorf("failed to parse vault file: %w", err)
+	state, err := decodeUnencryptedState(data)
+	if err != nil {
+		return err
 	}
 
-	v.state = &state
+	v.state = state
 	return nil
 }
 
+// decodeUnencryptedState parses the vault file, migrating it from older versions when needed.
+func decodeUnencryptedState(data []byte) (*UnencryptedState, error) {
+	var probe struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &probe); err != nil {
+		return nil, fmt.Errorf("failed to parse vault file: %w", err)
+	}
+
+	switch {
+	case probe.Version > unencryptedCurrentVaultVersion:
+		return nil, fmt.Errorf("unsupported vault version %d", probe.Version)
+	case probe.Version <= 1:
+		var legacy unencryptedStateV1
+		if err := json.Unmarshal(data, &legacy); err != nil {
+			return nil, fmt.Errorf("failed to parse vault file: %w", err)
+		}
+		return &UnencryptedState{
+			Metadata: legacy.Metadata,
+			Version:  unencryptedCurrentVaultVersion,
+			ID:       legacy.ID,
+			Secrets:  migrateSecretsV1(legacy.Secrets, legacy.LastModified),
+		}, nil
+	}
+
+	var state UnencryptedState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse vault file: %w", err)
+	}
+	return &state, nil
+}
+
 // save writes the vault contents to disk in JSON format
 func (v *UnencryptedVault) save() error {
 	if v.state == nil {
@@ -148,37 +194,82 @@ func (v *UnencryptedVault) Metadata() Metadata {
 }
 
 func (v *UnencryptedVault) GetSecret(key string) (Secret, error) {
+	return v.GetSecretContext(context.Background(), key)
+}
+
+func (v *UnencryptedVault) GetSecretContext(ctx context.Context, key string) (Secret, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
-	value, exists := v.state.Secrets[key]
+	entry, exists := v.state.Secrets[key]
 	if !exists {
 		return nil, ErrSecretNotFound
 	}
+	if entry.expired() {
+		return nil, ErrSecretExpired
+	}
 
-	return NewSecretValue([]byte(value)), nil
+	return NewSecretValue([]byte(entry.Value)), nil
 }
 
 func (v *UnencryptedVault) SetSecret(key string, secret Secret) error {
+	return v.SetSecretContext(context.Background(), key, secret)
+}
+
+func (v *UnencryptedVault) SetSecretContext(ctx context.Context, key string, secret Secret) error {
+	return v.setSecret(ctx, key, secret, SetOptions{})
+}
+
+func (v *UnencryptedVault) SetSecretWithOptions(key string, secret Secret, opts SetOptions) error {
+	return v.setSecret(context.Background(), key, secret, opts)
+}
+
+func (v *UnencryptedVault) setSecret(ctx context.Context, key string, secret Secret, opts SetOptions) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	if err := ValidateSecretKey(key); err != nil {
 		return err
 	}
+	if len(opts.Recipients) > 0 {
+		return fmt.Errorf("%w: secret recipients are only supported by age vaults", ErrNotSupported)
+	}
 
 	if v.state.Secrets == nil {
-		v.state.Secrets = make(map[string]string)
+		v.state.Secrets = make(map[string]*SecretEntry)
 	}
 
-	v.state.Secrets[key] = secret.PlainTextString()
+	entry, exists := v.state.Secrets[key]
+	if exists {
+		entry.setValue(secret.PlainTextString(), v.historySize)
+	} else {
+		entry = newSecretEntry(secret.PlainTextString())
+		v.state.Secrets[key] = entry
+	}
+	entry.Metadata.ExpiresAt = opts.expiresAt()
 	return v.save()
 }
 
 func (v *UnencryptedVault) DeleteSecret(key string) error {
+	return v.DeleteSecretContext(context.Background(), key)
+}
+
+func (v *UnencryptedVault) DeleteSecretContext(ctx context.Context, key string) error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
 
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	_, exists := v.state.Secrets[key]
 	if !exists {
 		return ErrSecretNotFound
@@ -189,6 +280,14 @@ func (v *UnencryptedVault) DeleteSecret(key string) error {
 }
 
 func (v *UnencryptedVault) ListSecrets() ([]string, error) {
+	return v.ListSecretsContext(context.Background())
+}
+
+func (v *UnencryptedVault) ListSecretsContext(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -203,6 +302,14 @@ func (v *UnencryptedVault) ListSecrets() ([]string, error) {
 }
 
 func (v *UnencryptedVault) HasSecret(key string) (bool, error) {
+	return v.HasSecretContext(context.Background(), key)
+}
+
+func (v *UnencryptedVault) HasSecretContext(ctx context.Context, key string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
 	v.mu.RLock()
 	defer v.mu.RUnlock()
 
@@ -210,6 +317,93 @@ func (v *UnencryptedVault) HasSecret(key string) (bool, error) {
 	return exists, nil
 }
 
+func (v *UnencryptedVault) GetSecretMetadata(key string) (SecretMetadata, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return SecretMetadata{}, ErrSecretNotFound
+	}
+
+	return entry.Metadata.copy(), nil
+}
+
+func (v *UnencryptedVault) ListSecretsWithMetadata() (map[string]SecretMetadata, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	return secretsMetadata(v.state.Secrets), nil
+}
+
+func (v *UnencryptedVault) SetSecretMetadata(key, description string, tags []string) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	entry.Metadata.Description = description
+	entry.Metadata.Tags = append([]string(nil), tags...)
+	return v.save()
+}
+
+func (v *UnencryptedVault) GetSecretVersion(key string, version int) (Secret, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+
+	value, err := entry.readVersion(key, version)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretValue([]byte(value)), nil
+}
+
+func (v *UnencryptedVault) ListSecretVersions(key string) ([]SecretVersionInfo, error) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return nil, ErrSecretNotFound
+	}
+
+	return entry.versions(), nil
+}
+
+func (v *UnencryptedVault) RollbackSecret(key string, version int) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	entry, exists := v.state.Secrets[key]
+	if !exists {
+		return ErrSecretNotFound
+	}
+
+	if err := entry.rollback(key, version, v.historySize); err != nil {
+		return err
+	}
+	return v.save()
+}
+
+func (v *UnencryptedVault) PurgeExpired() ([]string, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	purged := purgeExpired(v.state.Secrets)
+	if len(purged) == 0 {
+		return purged, nil
+	}
+	return purged, v.save()
+}
+
 func (v *UnencryptedVault) Close() error {
 	// clear the secret state from memory
 	v.mu.Lock()
@@ -168,8 +168,8 @@ func TestUnencryptedVault_FileFormat(t *testing.T) {
 	if vaultData["id"] != "test-vault" {
 		t.Errorf("Expected vault ID 'test-vault', got %v", vaultData["id"])
 	}
-	if vaultData["version"] != float64(1) {
-		t.Errorf("Expected version 1, got %v", vaultData["version"])
+	if vaultData["version"] != float64(2) {
+		t.Errorf("Expected version 2, got %v", vaultData["version"])
 	}
 
 	// Verify metadata exists
@@ -191,12 +191,15 @@ func TestUnencryptedVault_FileFormat(t *testing.T) {
 	}
 
 	for key, expectedValue := range secrets {
-		actualValue, valueExists := secretsData[key]
-		if !valueExists {
+		entry, entryExists := secretsData[key].(map[string]interface{})
+		if !entryExists {
 			t.Errorf("Expected secret key '%s' to be present", key)
 			continue
 		}
-		if actualValue != expectedValue {
+		if _, metadataExists := entry["metadata"]; !metadataExists {
+			t.Errorf("Expected metadata for secret key '%s'", key)
+		}
+		if actualValue := entry["value"]; actualValue != expectedValue {
 			t.Errorf("Expected secret value '%s' for key '%s', got '%v'", expectedValue, key, actualValue)
 		}
 	}
@@ -2,6 +2,8 @@ package vault
 
 import (
 	"fmt"
+
+	"github.com/flowexec/vault/crypto"
 )
 
 type Provider interface {
@@ -114,6 +116,31 @@ func WithLocalPath(path string) Option {
 	}
 }
 
+// WithHistorySize sets the number of prior secret versions kept by the vault (works for Age, AES, and
+// Unencrypted based on provider type)
+func WithHistorySize(size int) Option {
+	return func(c *Config) {
+		//nolint:exhaustive
+		switch c.Type {
+		case ProviderTypeAge:
+			if c.Age == nil {
+				c.Age = &AgeConfig{}
+			}
+			c.Age.HistorySize = size
+		case ProviderTypeAES256:
+			if c.Aes == nil {
+				c.Aes = &AesConfig{}
+			}
+			c.Aes.HistorySize = size
+		case ProviderTypeUnencrypted:
+			if c.Unencrypted == nil {
+				c.Unencrypted = &UnencryptedConfig{}
+			}
+			c.Unencrypted.HistorySize = size
+		}
+	}
+}
+
 // WithAgeIdentityFromEnv specifies to retrieve the age identity from an environment variable
 func WithAgeIdentityFromEnv(envVar string) Option {
 	return func(c *Config) {
@@ -146,6 +173,20 @@ func WithAgeIdentityFromFile(path string) Option {
 	}
 }
 
+// WithAgeIdentityFromCommand specifies to retrieve the age identity from the output of a command, such as a
+// password manager CLI
+func WithAgeIdentityFromCommand(command string) Option {
+	return func(c *Config) {
+		if c.Age == nil {
+			c.Age = &AgeConfig{}
+		}
+		c.Age.IdentitySources = append(
+			c.Age.IdentitySources,
+			IdentitySource{Type: commandSource, Command: command},
+		)
+	}
+}
+
 // WithAESKeyFromEnv specifies to retrieve the AES key from an environment variable
 func WithAESKeyFromEnv(envVar string) Option {
 	return func(c *Config) {
@@ -178,6 +219,66 @@ func WithAESKeyFromFile(path string) Option {
 	}
 }
 
+// WithAESKeyFromCommand specifies to retrieve the AES key from the output of a command, such as a password
+// manager CLI
+func WithAESKeyFromCommand(command string) Option {
+	return func(c *Config) {
+		if c.Aes == nil {
+			c.Aes = &AesConfig{}
+		}
+		c.Aes.KeySource = append(
+			c.Aes.KeySource,
+			KeySource{Type: commandSource, Command: command},
+		)
+	}
+}
+
+// WithAESPassphraseFromEnv specifies to derive the AES key from a passphrase stored in an environment variable
+func WithAESPassphraseFromEnv(envVar string) Option {
+	return func(c *Config) {
+		if c.Aes == nil {
+			c.Aes = &AesConfig{}
+		}
+		c.Aes.KeySource = append(
+			c.Aes.KeySource,
+			KeySource{Type: passphraseSource, Name: envVar},
+		)
+	}
+}
+
+// WithAESPassphraseFromFile specifies to derive the AES key from a passphrase stored in a file
+func WithAESPassphraseFromFile(path string) Option {
+	return func(c *Config) {
+		if c.Aes == nil {
+			c.Aes = &AesConfig{}
+		}
+		c.Aes.KeySource = append(
+			c.Aes.KeySource,
+			KeySource{Type: passphraseSource, Path: path},
+		)
+	}
+}
+
+// WithAESKDF sets the algorithm and cost parameters used to derive AES keys from passphrases
+func WithAESKDF(params crypto.KDFParams) Option {
+	return func(c *Config) {
+		if c.Aes == nil {
+			c.Aes = &AesConfig{}
+		}
+		c.Aes.KDF = &params
+	}
+}
+
+// WithAgePassphrase specifies to encrypt the age vault with a passphrase instead of recipients
+func WithAgePassphrase(source PassphraseSource) Option {
+	return func(c *Config) {
+		if c.Age == nil {
+			c.Age = &AgeConfig{}
+		}
+		c.Age.Passphrase = &source
+	}
+}
+
 // WithAgeRecipients sets the recipients for age vaults
 func WithAgeRecipients(recipients ...string) Option {
 	return func(c *Config) {
@@ -188,6 +289,37 @@ func WithAgeRecipients(recipients ...string) Option {
 	}
 }
 
+// WithAgeRecipientsFile sets the recipients file for age vaults
+func WithAgeRecipientsFile(path string) Option {
+	return func(c *Config) {
+		if c.Age == nil {
+			c.Age = &AgeConfig{}
+		}
+		c.Age.RecipientsFile = path
+	}
+}
+
+// WithAgeArmor writes age vault files in the ASCII armored format
+func WithAgeArmor() Option {
+	return func(c *Config) {
+		if c.Age == nil {
+			c.Age = &AgeConfig{}
+		}
+		c.Age.Armor = true
+	}
+}
+
+// WithAgePerSecretEncryption encrypts each secret of an age vault on its own so that secrets can be restricted to
+// a subset of the vault recipients
+func WithAgePerSecretEncryption() Option {
+	return func(c *Config) {
+		if c.Age == nil {
+			c.Age = &AgeConfig{}
+		}
+		c.Age.PerSecretEncryption = true
+	}
+}
+
 // WithExternalConfig sets the external vault configuration. FOR TESTING PURPOSES ONLY.
 // TODO: break this down when the external provider is fully implemented
 func WithExternalConfig(cfg *ExternalConfig) Option {
@@ -207,3 +339,29 @@ func HasRecipientManagement(v Provider) (RecipientManager, bool) {
 	rm, ok := v.(RecipientManager)
 	return rm, ok
 }
+
+// SecretRecipientManager is implemented by providers that can restrict individual secrets to a subset of the
+// vault's recipients. Secrets without their own recipients can be read by every vault recipient.
+type SecretRecipientManager interface {
+	AddSecretRecipient(key, publicKey string) error
+	RemoveSecretRecipient(key, publicKey string) error
+	// ListSecretRecipients returns the recipients that can read the secret
+	ListSecretRecipients(key string) ([]string, error)
+}
+
+func HasSecretRecipientManagement(v Provider) (SecretRecipientManager, bool) {
+	sm, ok := v.(SecretRecipientManager)
+	return sm, ok
+}
+
+// KeyManager is implemented by providers whose data key can be unlocked by more than one key-encryption key
+type KeyManager interface {
+	AddKey(key string) error
+	RemoveKey(keyID string) error
+	ListKeys() ([]string, error)
+}
+
+func HasKeyManagement(v Provider) (KeyManager, bool) {
+	km, ok := v.(KeyManager)
+	return km, ok
+}